@@ -0,0 +1,315 @@
+package capybara
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 访问日志的格式
+const (
+	// Common Log Format
+	ACCESS_LOG_COMMON = `${remote_ip} - ${user_id} [${time_clf}] "${method} ${uri} ${protocol}" ${status} ${bytes}`
+	// Combined Log Format
+	ACCESS_LOG_COMBINED = ACCESS_LOG_COMMON + ` "${referer}" "${user_agent}"`
+	// 每行一个 JSON 对象
+	ACCESS_LOG_JSON = "json"
+)
+
+// 访问日志中间件的配置
+type AccessLogConfig struct {
+	// 日志输出，默认为 os.Stdout
+	Output io.Writer
+	// 日志格式，默认为 ACCESS_LOG_COMBINED，也可以是 ACCESS_LOG_JSON 或自定义模板
+	//
+	// 模板中可以使用的变量：
+	//
+	//	${time_clf} ${time_rfc3339} ${latency} ${latency_ms} ${status} ${bytes}
+	//	${method} ${uri} ${path} ${route} ${protocol} ${host} ${remote_ip}
+	//	${user_agent} ${referer} ${request_id} ${user_id} ${header:名字}
+	//
+	// 来自请求的值按照 Apache 的方式转义，引号写成 \"，换行等控制字符写成 \xhh
+	Format string
+	// 不记录的路径，以 * 结尾时按前缀匹配
+	SkipPaths []string
+	// 不记录的状态码
+	SkipStatus []int
+	// 返回 true 时不记录
+	Skipper func(ctx Context) bool
+	// 成功请求（状态码小于 400）的采样率，取值 (0, 1)；为 0 时记录所有请求，失败的请求总是记录
+	SampleRate float64
+}
+
+// 访问日志的一条记录
+type accessLogEntry struct {
+	ctx     Context
+	start   time.Time
+	latency time.Duration
+}
+
+type accessLogToken func(e *accessLogEntry, buf *bytes.Buffer)
+
+// 访问日志中间件，建议通过 c.Use 注册以便记录找不到路由的请求
+//
+//	c.Use(capybara.AccessLog(capybara.AccessLogConfig{Format: capybara.ACCESS_LOG_JSON}))
+func AccessLog(config AccessLogConfig) Middlewares {
+	output := config.Output
+	if output == nil {
+		output = os.Stdout
+	}
+	format := config.Format
+	if format == "" {
+		format = ACCESS_LOG_COMBINED
+	}
+	var tokens []accessLogToken
+	if format != ACCESS_LOG_JSON {
+		tokens = compileAccessLog(format)
+	}
+	skipStatus := make(map[int]bool, len(config.SkipStatus))
+	for _, code := range config.SkipStatus {
+		skipStatus[code] = true
+	}
+	var mu sync.Mutex
+	pool := sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx Context) {
+			if skipPath(config.SkipPaths, ctx.Request().URL.Path) ||
+				config.Skipper != nil && config.Skipper(ctx) {
+				next(ctx)
+				return
+			}
+			start := time.Now()
+			next(ctx)
+			entry := &accessLogEntry{ctx: ctx, start: start, latency: time.Since(start)}
+
+			status := ctx.Response().Status
+			if skipStatus[status] {
+				return
+			}
+			if status < http.StatusBadRequest && config.SampleRate > 0 && rand.Float64() >= config.SampleRate {
+				return
+			}
+
+			buf := pool.Get().(*bytes.Buffer)
+			buf.Reset()
+			defer pool.Put(buf)
+			if tokens == nil {
+				entry.writeJSON(buf)
+			} else {
+				for _, token := range tokens {
+					token(entry, buf)
+				}
+				buf.WriteByte('\n')
+			}
+			// 整行一次写出，多个请求的日志不会交错
+			mu.Lock()
+			output.Write(buf.Bytes())
+			mu.Unlock()
+		}
+	}
+}
+
+func skipPath(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if pattern == path {
+			return true
+		}
+	}
+	return false
+}
+
+// 把模板编译成一组依次写出的片段，模板中有未知的变量时 panic
+func compileAccessLog(format string) []accessLogToken {
+	tokens := make([]accessLogToken, 0)
+	for format != "" {
+		start := strings.Index(format, "${")
+		if start < 0 {
+			tokens = append(tokens, literalToken(format))
+			break
+		}
+		end := strings.IndexByte(format[start:], '}')
+		if end < 0 {
+			tokens = append(tokens, literalToken(format))
+			break
+		}
+		if start > 0 {
+			tokens = append(tokens, literalToken(format[:start]))
+		}
+		name := format[start+2 : start+end]
+		token, ok := accessLogTokens[name]
+		if header, found := strings.CutPrefix(name, "header:"); found {
+			token, ok = headerToken(header), true
+		}
+		if !ok {
+			panic("capybara: unknown access log variable ${" + name + "}")
+		}
+		tokens = append(tokens, token)
+		format = format[start+end+1:]
+	}
+	return tokens
+}
+
+func literalToken(s string) accessLogToken {
+	return func(e *accessLogEntry, buf *bytes.Buffer) {
+		buf.WriteString(s)
+	}
+}
+
+func headerToken(name string) accessLogToken {
+	return func(e *accessLogEntry, buf *bytes.Buffer) {
+		writeOrDash(buf, e.ctx.Request().Header.Get(name))
+	}
+}
+
+// 空值按照 CLF 的习惯写成 -，其余值经过转义
+func writeOrDash(buf *bytes.Buffer, s string) {
+	if s == "" {
+		buf.WriteByte('-')
+		return
+	}
+	writeEscaped(buf, s)
+}
+
+// 按照 Apache 的方式转义来自客户端的值：" 与 \ 前加 \，换行等控制字符与非 ASCII 字节写成 \xhh，
+// 防止伪造日志行或者破坏引号内的字段
+func writeEscaped(buf *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+	for i := 0; i < len(s); i++ {
+		switch b := s[i]; b {
+		case '"', '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(b)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\v':
+			buf.WriteString(`\v`)
+		default:
+			if b < 0x20 || b >= 0x7f {
+				buf.WriteString(`\x`)
+				buf.WriteByte(hex[b>>4])
+				buf.WriteByte(hex[b&0x0f])
+				continue
+			}
+			buf.WriteByte(b)
+		}
+	}
+}
+
+var accessLogTokens = map[string]accessLogToken{
+	"time_clf": func(e *accessLogEntry, buf *bytes.Buffer) {
+		buf.WriteString(e.start.Format("02/Jan/2006:15:04:05 -0700"))
+	},
+	"time_rfc3339": func(e *accessLogEntry, buf *bytes.Buffer) {
+		buf.WriteString(e.start.Format(time.RFC3339))
+	},
+	"latency": func(e *accessLogEntry, buf *bytes.Buffer) {
+		buf.WriteString(e.latency.String())
+	},
+	"latency_ms": func(e *accessLogEntry, buf *bytes.Buffer) {
+		buf.WriteString(strconv.FormatFloat(float64(e.latency)/float64(time.Millisecond), 'f', 3, 64))
+	},
+	"status": func(e *accessLogEntry, buf *bytes.Buffer) {
+		buf.WriteString(strconv.Itoa(e.ctx.Response().Status))
+	},
+	"bytes": func(e *accessLogEntry, buf *bytes.Buffer) {
+		if size := e.ctx.Response().Size; size > 0 {
+			buf.WriteString(strconv.FormatInt(size, 10))
+			return
+		}
+		buf.WriteByte('-')
+	},
+	"method": func(e *accessLogEntry, buf *bytes.Buffer) {
+		writeEscaped(buf, e.ctx.Request().Method)
+	},
+	"uri": func(e *accessLogEntry, buf *bytes.Buffer) {
+		writeEscaped(buf, e.ctx.Request().RequestURI)
+	},
+	"path": func(e *accessLogEntry, buf *bytes.Buffer) {
+		writeEscaped(buf, e.ctx.Request().URL.Path)
+	},
+	"route": func(e *accessLogEntry, buf *bytes.Buffer) {
+		writeOrDash(buf, e.ctx.Path())
+	},
+	"protocol": func(e *accessLogEntry, buf *bytes.Buffer) {
+		writeEscaped(buf, e.ctx.Request().Proto)
+	},
+	"host": func(e *accessLogEntry, buf *bytes.Buffer) {
+		writeEscaped(buf, e.ctx.Host())
+	},
+	"remote_ip": func(e *accessLogEntry, buf *bytes.Buffer) {
+		buf.WriteString(e.ctx.RealIP())
+	},
+	"user_agent": func(e *accessLogEntry, buf *bytes.Buffer) {
+		writeOrDash(buf, e.ctx.Request().UserAgent())
+	},
+	"referer": func(e *accessLogEntry, buf *bytes.Buffer) {
+		writeOrDash(buf, e.ctx.Request().Referer())
+	},
+	"request_id": func(e *accessLogEntry, buf *bytes.Buffer) {
+		requestID, _ := RequestIDKey.Value(e.ctx)
+		writeOrDash(buf, requestID)
+	},
+	"user_id": func(e *accessLogEntry, buf *bytes.Buffer) {
+		userID, _ := UserIDKey.Value(e.ctx)
+		writeOrDash(buf, userID)
+	},
+}
+
+func (e *accessLogEntry) writeJSON(buf *bytes.Buffer) {
+	r := e.ctx.Request()
+	requestID, _ := RequestIDKey.Value(e.ctx)
+	userID, _ := UserIDKey.Value(e.ctx)
+	record := struct {
+		Time      string  `json:"time"`
+		RequestID string  `json:"request_id,omitempty"`
+		RemoteIP  string  `json:"remote_ip"`
+		Host      string  `json:"host"`
+		Method    string  `json:"method"`
+		URI       string  `json:"uri"`
+		Route     string  `json:"route,omitempty"`
+		Protocol  string  `json:"protocol"`
+		Status    int     `json:"status"`
+		Bytes     int64   `json:"bytes"`
+		LatencyMS float64 `json:"latency_ms"`
+		UserAgent string  `json:"user_agent,omitempty"`
+		Referer   string  `json:"referer,omitempty"`
+		UserID    string  `json:"user_id,omitempty"`
+	}{
+		Time:      e.start.Format(time.RFC3339Nano),
+		RequestID: requestID,
+		RemoteIP:  e.ctx.RealIP(),
+		Host:      e.ctx.Host(),
+		Method:    r.Method,
+		URI:       r.RequestURI,
+		Route:     e.ctx.Path(),
+		Protocol:  r.Proto,
+		Status:    e.ctx.Response().Status,
+		Bytes:     e.ctx.Response().Size,
+		LatencyMS: float64(e.latency) / float64(time.Millisecond),
+		UserAgent: r.UserAgent(),
+		Referer:   r.Referer(),
+		UserID:    userID,
+	}
+	if err := json.NewEncoder(buf).Encode(record); err != nil {
+		fmt.Fprintf(buf, "{\"error\":%q}\n", err.Error())
+	}
+}
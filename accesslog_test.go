@@ -0,0 +1,99 @@
+package capybara
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func serveAccessLog(config AccessLogConfig, targets ...string) string {
+	buf := new(bytes.Buffer)
+	config.Output = buf
+	c := CreateCapybaraInstance()
+	c.Use(RequestID(RequestIDConfig{}), AccessLog(config))
+	c.GET("/users/:id", func(ctx Context) {
+		ctx.String(200, "capybara")
+	})
+	for _, target := range targets {
+		r := httptest.NewRequest("GET", target, nil)
+		r.RemoteAddr = "203.0.113.7:1234"
+		r.Header.Set("User-Agent", "test-agent")
+		r.Header.Set(HEADER_X_REQUEST_ID, "req-1")
+		c.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	return buf.String()
+}
+
+// 测试 Combined Log Format
+func TestAccessLogCombined(t *testing.T) {
+	line := serveAccessLog(AccessLogConfig{}, "/users/7?a=1")
+	pattern := `^203\.0\.113\.7 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /users/7\?a=1 HTTP/1\.1" 200 8 "-" "test-agent"\n$`
+	if !regexp.MustCompile(pattern).MatchString(line) {
+		t.Errorf("Combined 格式异常: %q", line)
+	}
+}
+
+// 测试来自请求的值被转义
+func TestAccessLogEscape(t *testing.T) {
+	buf := new(bytes.Buffer)
+	c := CreateCapybaraInstance()
+	c.Use(AccessLog(AccessLogConfig{Output: buf}))
+	r := httptest.NewRequest("GET", "/a", nil)
+	r.RequestURI = "/a\" HTTP/1.1\" 200 1 \"-\" \"x"
+	r.Header.Set("User-Agent", "evil\n203.0.113.1 - - \"GET / HTTP/1.1\"")
+	r.Header.Set("Referer", "a\\b\x1b[31m\xff")
+	c.ServeHTTP(httptest.NewRecorder(), r)
+
+	line := buf.String()
+	if strings.Count(line, "\n") != 1 || !strings.HasSuffix(line, "\n") {
+		t.Fatalf("换行没有被转义: %q", line)
+	}
+	if !strings.Contains(line, `"GET /a\" HTTP/1.1\" 200 1 \"-\" \"x HTTP/1.1" 404`) {
+		t.Errorf("请求行没有被转义: %q", line)
+	}
+	if !strings.HasSuffix(line, ` "a\\b\x1b[31m\xff" "evil\n203.0.113.1 - - \"GET / HTTP/1.1\""`+"\n") {
+		t.Errorf("Referer 或 User-Agent 没有被转义: %q", line)
+	}
+}
+
+// 测试 JSON 格式与自定义模板
+func TestAccessLogFormats(t *testing.T) {
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(serveAccessLog(AccessLogConfig{Format: ACCESS_LOG_JSON}, "/users/7")), &record); err != nil {
+		t.Fatalf("JSON 解析失败: %v", err)
+	}
+	if record["route"] != "/users/:id" || record["status"] != 200.0 || record["request_id"] != "req-1" {
+		t.Errorf("JSON 内容异常: %v", record)
+	}
+
+	line := serveAccessLog(AccessLogConfig{Format: "${request_id} ${route} ${status} ${header:User-Agent}"}, "/users/7", "/missing")
+	if line != "req-1 /users/:id 200 test-agent\nreq-1 - 404 test-agent\n" {
+		t.Errorf("模板输出异常: %q", line)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("未知的变量没有 panic")
+		}
+	}()
+	AccessLog(AccessLogConfig{Format: "${unknown}"})
+}
+
+// 测试跳过与采样
+func TestAccessLogSkip(t *testing.T) {
+	line := serveAccessLog(AccessLogConfig{Format: "${path}", SkipPaths: []string{"/users/*"}, SkipStatus: []int{404}}, "/users/7", "/missing")
+	if line != "" {
+		t.Errorf("没有跳过: %q", line)
+	}
+	// 采样只作用于成功的请求
+	line = serveAccessLog(AccessLogConfig{Format: "${path}", SampleRate: 1e-9}, "/users/7", "/missing")
+	if line != "/missing\n" {
+		t.Errorf("采样异常: %q", line)
+	}
+	if strings.Count(serveAccessLog(AccessLogConfig{Format: "${path}"}, "/users/1", "/users/2"), "\n") != 2 {
+		t.Error("默认应记录所有请求")
+	}
+}
@@ -0,0 +1,167 @@
+package capybara
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// 缓冲区满时的处理方式
+const (
+	// 丢弃这次写入，不阻塞请求
+	ASYNC_DROP = iota
+	// 等待缓冲区有空位
+	ASYNC_BLOCK
+)
+
+var ErrWriterClosed = errors.New("writer already closed")
+
+// 异步写入的配置
+type AsyncConfig struct {
+	// 缓冲的写入次数，默认为 1024
+	BufferSize int
+	// ASYNC_DROP 或 ASYNC_BLOCK，默认丢弃
+	Policy int
+	// 写入底层 Writer 出错时调用
+	OnError func(err error)
+}
+
+type asyncEntry struct {
+	data []byte
+	// 不为 nil 时表示 Flush，写完之前的数据后关闭
+	flushed chan struct{}
+}
+
+// 在后台 goroutine 中写入底层 Writer，访问日志不会因为磁盘变慢阻塞请求
+//
+//	file, _ := capybara.NewRotatingFile(capybara.RotateConfig{Filename: "logs/access.log"})
+//	out := capybara.NewAsyncWriter(file, capybara.AsyncConfig{})
+//	c.OnShutdown(func() { out.Close() })
+//	c.Use(capybara.AccessLog(capybara.AccessLogConfig{Output: out}))
+type AsyncWriter struct {
+	w      io.Writer
+	config AsyncConfig
+	queue  chan asyncEntry
+	mu     sync.RWMutex
+	closed bool
+	// Close 时关闭，让等待缓冲区空位的写入立即返回
+	closing chan struct{}
+	// 正在向缓冲区发送的写入，全部返回后才能关闭 queue
+	sending sync.WaitGroup
+	done    chan struct{}
+	dropped atomic.Uint64
+}
+
+func NewAsyncWriter(w io.Writer, config AsyncConfig) *AsyncWriter {
+	if config.BufferSize <= 0 {
+		config.BufferSize = 1024
+	}
+	a := &AsyncWriter{
+		w:       w,
+		config:  config,
+		queue:   make(chan asyncEntry, config.BufferSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+func (a *AsyncWriter) run() {
+	defer close(a.done)
+	for entry := range a.queue {
+		if entry.flushed != nil {
+			close(entry.flushed)
+			continue
+		}
+		if _, err := a.w.Write(entry.data); err != nil && a.config.OnError != nil {
+			a.config.OnError(err)
+		}
+	}
+}
+
+// 复制数据后放入缓冲区，缓冲区满时按照 Policy 丢弃或等待，等待中 Close 时返回 ErrWriterClosed
+func (a *AsyncWriter) Write(p []byte) (int, error) {
+	entry := asyncEntry{data: append([]byte(nil), p...)}
+	if a.config.Policy == ASYNC_BLOCK {
+		if err := a.send(entry); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if !a.begin() {
+		return 0, ErrWriterClosed
+	}
+	defer a.sending.Done()
+	select {
+	case a.queue <- entry:
+	default:
+		a.dropped.Add(1)
+	}
+	// 丢弃的数据同样当作写入成功，调用方不需要处理
+	return len(p), nil
+}
+
+// 在锁内确认没有关闭并登记一次发送，发送本身在锁外进行，不会阻塞 Close
+func (a *AsyncWriter) begin() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return false
+	}
+	a.sending.Add(1)
+	return true
+}
+
+// 等待缓冲区有空位，期间关闭时放弃
+func (a *AsyncWriter) send(entry asyncEntry) error {
+	if !a.begin() {
+		return ErrWriterClosed
+	}
+	defer a.sending.Done()
+	select {
+	case a.queue <- entry:
+		return nil
+	case <-a.closing:
+		return ErrWriterClosed
+	}
+}
+
+// 被丢弃的写入次数
+func (a *AsyncWriter) Dropped() uint64 {
+	return a.dropped.Load()
+}
+
+// 等待之前的数据写入底层 Writer
+func (a *AsyncWriter) Flush() error {
+	flushed := make(chan struct{})
+	if err := a.send(asyncEntry{flushed: flushed}); err != nil {
+		return err
+	}
+	// 已经放入缓冲区的 Flush 在关闭时同样会被处理
+	<-flushed
+	return nil
+}
+
+// 写完缓冲区中的数据后关闭，底层 Writer 实现了 io.Closer 时一并关闭
+//
+// 等待缓冲区空位的 Write 与 Flush 会返回 ErrWriterClosed
+func (a *AsyncWriter) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	close(a.closing)
+	a.mu.Unlock()
+	// 之后不会再有新的发送，等待进行中的发送返回后才能关闭 queue
+	a.sending.Wait()
+	close(a.queue)
+	<-a.done
+	if closer, ok := a.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
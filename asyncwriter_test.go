@@ -0,0 +1,110 @@
+package capybara
+
+import (
+	"bytes"
+	gocontext "context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 写入时阻塞的 Writer
+type blockingWriter struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	release chan struct{}
+	closed  bool
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *blockingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	return nil
+}
+
+// 测试缓冲区满时丢弃，关闭时写出缓冲中的数据
+func TestAsyncWriterDrop(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	a := NewAsyncWriter(w, AsyncConfig{BufferSize: 2})
+	// 第一条被后台 goroutine 取走后阻塞，随后两条进入缓冲区，其余被丢弃
+	a.Write([]byte("1"))
+	for a.Dropped() == 0 {
+		a.Write([]byte("x"))
+	}
+	close(w.release)
+	if err := a.Close(); err != nil {
+		t.Fatalf("关闭失败: %v", err)
+	}
+	if !w.closed {
+		t.Error("底层 Writer 没有被关闭")
+	}
+	if w.buf.Len() > 3 || w.buf.String()[0] != '1' {
+		t.Errorf("写出的内容异常: %q", w.buf.String())
+	}
+	if _, err := a.Write([]byte("2")); err != ErrWriterClosed {
+		t.Errorf("关闭后仍可写入: %v", err)
+	}
+}
+
+// 测试阻塞模式下不丢数据，以及 Shutdown 时执行注册的函数
+func TestAsyncWriterBlock(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	close(w.release)
+	a := NewAsyncWriter(w, AsyncConfig{BufferSize: 1, Policy: ASYNC_BLOCK})
+	for i := 0; i < 100; i++ {
+		a.Write([]byte("x"))
+	}
+	a.Flush()
+	if w.buf.Len() != 100 || a.Dropped() != 0 {
+		t.Errorf("阻塞模式丢失了数据: %d %d", w.buf.Len(), a.Dropped())
+	}
+
+	c := CreateCapybaraInstance()
+	c.OnShutdown(func() { a.Close() })
+	c.Shutdown(gocontext.Background())
+	if !w.closed {
+		t.Error("Shutdown 没有关闭 AsyncWriter")
+	}
+}
+
+// 测试阻塞模式下底层 Writer 卡住时，Close 会让等待中的写入返回
+func TestAsyncWriterCloseBlocked(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	a := NewAsyncWriter(w, AsyncConfig{BufferSize: 1, Policy: ASYNC_BLOCK})
+	errs := make(chan error, 3)
+	go func() {
+		for i := 0; i < 3; i++ {
+			_, err := a.Write([]byte("x"))
+			errs <- err
+		}
+	}()
+	// 第一条被后台 goroutine 取走后阻塞，第二条进入缓冲区，第三条等待空位
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("写入失败: %v", err)
+		}
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- a.Close() }()
+	select {
+	case err := <-errs:
+		if err != ErrWriterClosed {
+			t.Errorf("等待中的写入返回异常: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close 没有让等待中的写入返回")
+	}
+	close(w.release)
+	if err := <-closed; err != nil || w.buf.String() != "xx" {
+		t.Errorf("关闭时缓冲中的数据没有写出: %v %q", err, w.buf.String())
+	}
+}
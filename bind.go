@@ -0,0 +1,148 @@
+package capybara
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrBindTarget = errors.New("bind target must be a pointer to a struct")
+
+// 将路由参数绑定到结构体中带 param 标签的字段
+//
+//	type Req struct {
+//		ID int `param:"id"`
+//	}
+func (c *context) BindPath(data interface{}) error {
+	c.assertAlive()
+	values := make(map[string][]string, len(c.params))
+	for k, v := range c.params {
+		values[k] = []string{v}
+	}
+	return bindValues(data, "param", values)
+}
+
+// 将查询参数绑定到结构体中带 query 标签的字段，切片字段接收重复出现的参数
+//
+//	type Req struct {
+//		Page int      `query:"page"`
+//		Tags []string `query:"tag"`
+//	}
+func (c *context) BindQuery(data interface{}) error {
+	c.assertAlive()
+	return bindValues(data, "query", c.r.URL.Query())
+}
+
+func bindValues(data interface{}, tag string, values map[string][]string) error {
+	v := reflect.ValueOf(data)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return ErrBindTarget
+	}
+	return bindStruct(v.Elem(), tag, values)
+}
+
+func bindStruct(v reflect.Value, tag string, values map[string][]string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get(tag), ",")
+		if name == "-" {
+			continue
+		}
+		// 没有标签的嵌入结构体展开绑定
+		if name == "" {
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				if err := bindStruct(v.Field(i), tag, values); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		vals, ok := values[name]
+		if !ok || len(vals) == 0 {
+			continue
+		}
+		if err := setField(v.Field(i), vals); err != nil {
+			return fmt.Errorf("%s %q: %w", tag, name, err)
+		}
+	}
+	return nil
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+func setField(field reflect.Value, vals []string) error {
+	if field.Kind() == reflect.Slice && field.Type().Elem().Kind() != reflect.Uint8 &&
+		!reflect.PointerTo(field.Type()).Implements(textUnmarshalerType) {
+		slice := reflect.MakeSlice(field.Type(), len(vals), len(vals))
+		for i, val := range vals {
+			if err := setValue(slice.Index(i), val); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	}
+	return setValue(field, vals[0])
+}
+
+// 将字符串转换成字段的类型
+func setValue(field reflect.Value, val string) error {
+	if field.Kind() == reflect.Pointer {
+		if field.IsNil() {
+			field.Set(reflect.New(field.Type().Elem()))
+		}
+		return setValue(field.Elem(), val)
+	}
+	if field.CanAddr() {
+		if u, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return u.UnmarshalText([]byte(val))
+		}
+	}
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(val)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(val, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(val, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(val, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(n)
+	default:
+		return errors.New("unsupported field type " + field.Type().String())
+	}
+	return nil
+}
@@ -1,9 +1,13 @@
 package capybara
 
 import (
-	"encoding/json"
+	gocontext "context"
+	"fmt"
+	"net"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/crypto/acme/autocert"
 )
@@ -11,8 +15,10 @@ import (
 // MIME
 const (
 	// application type
-	APPLICATION_JSON = "application/json"
-	APPLICATION_XML  = "application/xml"
+	APPLICATION_JSON         = "application/json"
+	APPLICATION_XML          = "application/xml"
+	APPLICATION_JAVASCRIPT   = "application/javascript"
+	APPLICATION_OCTET_STREAM = "application/octet-stream"
 	// text type
 	TEXT_XML   = "text/xml"
 	TEXT_HTML  = "text/html"
@@ -20,7 +26,12 @@ const (
 )
 
 const (
-	CONTENT_TYPE = "Content-Type"
+	CONTENT_TYPE        = "Content-Type"
+	CONTENT_DISPOSITION = "Content-Disposition"
+	LOCATION            = "Location"
+	ALLOW               = "Allow"
+	// 禁止浏览器猜测响应类型
+	X_CONTENT_TYPE_OPTIONS = "X-Content-Type-Options"
 )
 
 type HandlerFunc func(Context)
@@ -31,8 +42,42 @@ type Middlewares func(HandlerFunc) HandlerFunc
 type capybara struct {
 	router     *Router
 	pool       sync.Pool
-	logger     *CapybaraLogger
 	TLSManager autocert.Manager
+	// 日志，默认以文本格式输出到 os.Stderr
+	Logger Logger
+	// 调试模式：请求结束后的 Context 不再复用，之后再使用会 panic；开启 -race 时默认打开
+	Debug bool
+	// JSON 序列化器，JSON、Bind 以及错误响应使用
+	JSONSerializer JSONSerializer
+	// 集中的错误处理函数，Context.Error 使用
+	HTTPErrorHandler HTTPErrorHandler
+	// 请求校验器，Handle 使用
+	Validator Validator
+	// 模板渲染器，Context.Render 使用
+	Renderer Renderer
+	// 内容协商使用的编码器
+	encoders []negotiateEncoder
+	// WebSocket 握手与连接的配置
+	WebSocket WebSocketConfig
+	// WebSocket / SSE 的发布订阅中心
+	Hub *Hub
+	// 指标的注册中心，RequestMetrics 与自定义指标使用
+	Metrics *Registry
+	// 路由的文档信息，key 为 "方法 路径"
+	routeDocs map[string]RouteDoc
+	// 不出现在 OpenAPI 文档中的路由
+	hiddenRoutes map[string]bool
+	// 按状态码与按错误注册的问题类型
+	problemTypes  map[int]ProblemType
+	problemErrors []problemError
+	// 作用于所有请求的中间件
+	middlewares []Middlewares
+	// 受信任的代理网段
+	trustedProxies []*net.IPNet
+	// Run 启动的服务，Shutdown 使用
+	server     *http.Server
+	serverMu   sync.Mutex
+	onShutdown []func()
 }
 
 // 启动一个capybara实例
@@ -44,56 +89,106 @@ func CreateCapybaraInstance() *capybara {
 				// 当池中无可用对象时，自动调用此函数创建新对象
 				return new(context)
 			}},
-		logger: InitLogger(),
+		Logger:         NewLogger(LoggerConfig{}),
+		encoders:       defaultEncoders(),
+		JSONSerializer: &DefaultJSONSerializer{},
+		Hub:            NewHub(HubConfig{}),
+		Metrics:        NewRegistry(),
+		Debug:          raceEnabled,
 		TLSManager: autocert.Manager{
 			Prompt: autocert.AcceptTOS,
 		},
 	}
 	c.router.c = c
+	c.HTTPErrorHandler = c.DefaultHTTPErrorHandler
+	c.trustedProxies, _ = parseTrustedProxies(defaultTrustedProxies)
 	return c
 }
 
 // 启动非https 的服务
 func (c *capybara) Run(addr string) error {
-	c.logger.Info(addr + " running")
-	err := http.ListenAndServe(addr, c)
-	return err
+	c.logger().Info("server running", "addr", addr)
+	return c.newServer(addr).ListenAndServe()
 }
 
 // 启动https 的服务
 func (c *capybara) RunTLS(addr string, certFile string, keyFile string) error {
-	c.logger.Info(addr + " running TLS")
-	err := http.ListenAndServeTLS(addr, certFile, keyFile, c)
+	c.logger().Info("server running", "addr", addr, "tls", true)
+	return c.newServer(addr).ListenAndServeTLS(certFile, keyFile)
+}
+
+func (c *capybara) newServer(addr string) *http.Server {
+	c.serverMu.Lock()
+	defer c.serverMu.Unlock()
+	c.server = &http.Server{Addr: addr, Handler: c}
+	return c.server
+}
+
+// 优雅关闭：不再接收新的连接，等待正在处理的请求结束后，依次执行 OnShutdown 注册的函数
+//
+// ctx 超时后同样会执行注册的函数，保证缓冲中的日志被写出
+func (c *capybara) Shutdown(ctx gocontext.Context) error {
+	c.serverMu.Lock()
+	server := c.server
+	c.serverMu.Unlock()
+	var err error
+	if server != nil {
+		err = server.Shutdown(ctx)
+	}
+	for _, fn := range c.onShutdown {
+		fn()
+	}
 	return err
 }
 
+// 注册关闭时执行的函数，例如关闭 AsyncWriter
+func (c *capybara) OnShutdown(fn func()) {
+	c.onShutdown = append(c.onShutdown, fn)
+}
+
 func (c *capybara) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// 从池中取出一个context对象
+	currContext := c.pool.Get().(*context)
+	// 确保方法结束时归还这个context
+	defer c.releaseContext(currContext)
+	currContext.Reset()
+
+	var handler HandlerFunc
 	currNode, params := c.router.tree.FindRoute(r.URL.Path)
 	if currNode != nil {
-		if currNode.method != r.Method {
-			sendError(500, w, "Error method")
-			return
-		}
-		// 从池中取出一个context对象
-		currContext := c.pool.Get().(*context)
-		// 确保方法结束时关闭这个池
-		defer c.pool.Put(currContext)
-		currContext.Reset()
 		currContext.ApplyContext(c, params, w, r)
 		currContext.path = currNode.fullPath
-		currContext.handler = currNode.handler
-
-		currNode.handler(currContext)
+		handler = currNode.handler(r.Method)
+		if handler == nil {
+			allow := strings.Join(currNode.allowedMethods(), ", ")
+			handler = func(ctx Context) {
+				ctx.Response().Header().Set(ALLOW, allow)
+				ctx.Error(NewHTTPError(http.StatusMethodNotAllowed))
+			}
+		}
 	} else {
-		sendError(500, w, "Error url")
+		currContext.ApplyContext(c, nil, w, r)
+		handler = func(ctx Context) {
+			ctx.Error(NewHTTPError(http.StatusNotFound))
+		}
 	}
+	currContext.handler = handler
+
+	// 实例级别的中间件作用于所有请求，包括找不到路由的请求
+	if len(c.middlewares) != 0 {
+		handler = applyMiddlewares(handler, c.middlewares...)
+	}
+	handler(currContext)
+}
+
+// 添加作用于所有请求的中间件
+func (c *capybara) Use(middlewares ...Middlewares) {
+	c.middlewares = append(c.middlewares, middlewares...)
 }
 
-func sendError(code int, w http.ResponseWriter, data interface{}) {
-	w.Header().Set(CONTENT_TYPE, APPLICATION_JSON)
-	w.WriteHeader(code)
-	jsonEncoder := json.NewEncoder(w)
-	jsonEncoder.Encode(map[string]interface{}{"error": data})
+// 发送 Problem Details 格式的错误响应，detail 可以为空
+func (c *capybara) sendError(code int, w http.ResponseWriter, r *http.Request, detail string) {
+	c.sendProblem(w, r, NewProblem(code, detail))
 }
 
 func (c *capybara) GET(path string, handler HandlerFunc, middlewares ...Middlewares) {
@@ -136,6 +231,12 @@ func (c *capybara) TRACE(path string, handler HandlerFunc, middlewares ...Middle
 	c.router.tree.insertRoute(path, "TRACE", h)
 }
 
+// 按请求方法注册路由
+func (c *capybara) Add(method string, path string, handler HandlerFunc, middlewares ...Middlewares) {
+	h := applyMiddlewares(handler, middlewares...)
+	c.router.tree.insertRoute(path, method, h)
+}
+
 func applyMiddlewares(handler HandlerFunc, middlewares ...Middlewares) HandlerFunc {
 	for i := len(middlewares) - 1; i >= 0; i-- {
 		handler = middlewares[i](handler)
@@ -152,12 +253,32 @@ func (c *capybara) Group(prefix string) *Router {
 	}
 }
 
+// 为路由设置超时，超时后请求的 context 会被取消
+//
+// 路由函数没有写出响应就因为超时返回时，发送 503
+//
+//	c.GET("/report", handler, capybara.Timeout(5*time.Second))
+func Timeout(timeout time.Duration) Middlewares {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx Context) {
+			timeoutCtx, cancel := gocontext.WithTimeout(ctx.Request().Context(), timeout)
+			defer cancel()
+			ctx.SetContext(timeoutCtx)
+			next(ctx)
+			if timeoutCtx.Err() == gocontext.DeadlineExceeded && !ctx.Response().Committed {
+				ctx.Error(NewHTTPError(http.StatusServiceUnavailable))
+			}
+		}
+	}
+}
+
+// 捕获路由函数的 panic，交给集中的错误处理函数发送 500
 func Recovery() Middlewares {
 	return func(next HandlerFunc) HandlerFunc {
 		return func(ctx Context) {
 			defer func() {
 				if err := recover(); err != nil {
-					ctx.JSON(502, map[string]string{"error": "StatusInternalServerError"})
+					ctx.Error(NewHTTPError(http.StatusInternalServerError).SetInternal(fmt.Errorf("panic: %v", err)))
 				}
 			}()
 			next(ctx)
@@ -1,23 +1,46 @@
 package capybara
 
 import (
-	"encoding/json"
+	"bytes"
+	gocontext "context"
 	"encoding/xml"
 	"errors"
 	"io"
+	"io/fs"
+	"net"
 	"net/http"
+	"net/url"
+	"regexp"
 	"strings"
+	"time"
 )
 
+var (
+	ErrInvalidRedirectCode = errors.New("invalid redirect status code")
+	ErrInvalidCallback     = errors.New("invalid jsonp callback")
+)
+
+// JSONP 回调只允许 JavaScript 标识符或者以 . 连接的成员访问，例如 jQuery.cb
+var jsonpCallbackPattern = regexp.MustCompile(`^[A-Za-z_$][\w$]*(\.[A-Za-z_$][\w$]*)*$`)
+
 // **** context
 type Context interface {
+	// 请求的取消与超时，可以直接传给数据库或 HTTP 客户端
+	gocontext.Context
+	SetContext(ctx gocontext.Context)
+
 	// 请求与响应对象操作
 	Request() *http.Request
+	Response() *Response
 
 	// 连接信息检查
 	IsTLS() bool
 	IsWebSocket() bool
 	RealIP() string
+	Scheme() string
+	Host() string
+	BaseURL() string
+	AbsoluteURL(path string) string
 
 	// 路径参数处理
 	Param(name string) string
@@ -28,6 +51,22 @@ type Context interface {
 	XML(code int, data interface{}) error
 	String(code int, s string) error
 	HTML(code int, html string) error
+	NoContent(code int) error
+	Redirect(code int, url string) error
+	Blob(code int, contentType string, b []byte) error
+	Stream(code int, contentType string, r io.Reader) error
+	JSONPretty(code int, data interface{}, indent string) error
+	JSONP(code int, callback string, data interface{}) error
+	JSONBlob(code int, b []byte) error
+	XMLBlob(code int, b []byte) error
+	Attachment(file string, name string) error
+	Inline(file string, name string) error
+	File(file string) error
+	FileFS(fsys fs.FS, name string) error
+	Render(code int, name string, data interface{}) error
+	Negotiate(code int, data interface{}) error
+	SSE() (*SSEWriter, error)
+	Upgrade() (*WebSocketConn, error)
 
 	// 上下文数据存储
 	Set(key string, value interface{})
@@ -35,6 +74,8 @@ type Context interface {
 
 	// 数据绑定与验证
 	Bind(data interface{}) (err error)
+	BindPath(data interface{}) error
+	BindQuery(data interface{}) error
 
 	// 获取信息
 	GetHeader(key string) string
@@ -43,47 +84,76 @@ type Context interface {
 	// 请求数据提取
 	Cookie(name string) (*http.Cookie, error)
 	Cookies() []*http.Cookie
+
+	// 交给集中的错误处理函数
+	Error(err error)
+	// 以 RFC 9457 Problem Details 的格式发送错误响应
+	Problem(p *Problem) error
+	// 请求级别的日志
+	Logger() Logger
+	// 在当前请求的 Span 下开始一个子 Span
+	StartSpan(name string) *Span
+
+	// 复制一份可以在 goroutine 中使用的 Context
+	Copy() Context
 }
 
 type context struct {
-	w       http.ResponseWriter
-	r       *http.Request
-	data    map[string]interface{}
+	w    http.ResponseWriter
+	resp Response
+	r    *http.Request
+	data map[string]interface{}
+	// Key[T] 保存的数据
+	values  map[interface{}]interface{}
 	capa    *capybara
 	params  map[string]string
 	path    string
 	handler HandlerFunc
+	// 请求级别的日志，第一次调用 Logger 时生成
+	logger *requestLogger
+	// 调试模式下请求结束后被标记为已释放
+	released bool
+}
+
+// 取得 Context 所属的实例，不是框架创建的 Context 时返回 nil
+func instanceOf(ctx Context) *capybara {
+	if c, ok := ctx.(*context); ok {
+		return c.capa
+	}
+	return nil
 }
 
 // 应用到当前的 context
 func (c *context) ApplyContext(cap *capybara, params map[string]string, w http.ResponseWriter, r *http.Request) {
 	c.capa = cap
-	c.w = w
+	c.resp.reset(w)
+	c.w = &c.resp
 	c.r = r
-	c.data = make(map[string]interface{})
 	c.params = params
 }
 
 func (c *context) Reset() {
 	c.w = nil
+	c.resp.reset(nil)
 	c.r = nil
-	c.data = make(map[string]interface{}, 0)
+	// 数据在第一次 Set 时才分配
+	c.data = nil
+	c.values = nil
 	c.capa = nil
 	c.params = make(map[string]string)
 	c.path = ""
 	c.handler = nil
+	c.logger = nil
+	c.released = false
 }
 
 // 发送JSON格式的文件
 func (c *context) JSON(code int, data interface{}) error {
-	c.w.Header().Set(CONTENT_TYPE, APPLICATION_JSON)
-	c.w.WriteHeader(code)
-	jsonEncoder := json.NewEncoder(c.w)
-	err := jsonEncoder.Encode(data)
+	b, err := c.capa.marshalJSON(data, "")
 	if err != nil {
 		return c.String(http.StatusInternalServerError, "解析json出错")
 	}
-	return nil
+	return c.JSONBlob(code, b)
 }
 
 // 发送String格式的文件
@@ -114,6 +184,115 @@ func (c *context) HTML(code int, html string) error {
 	return err
 }
 
+// 只发送状态码，不带响应体
+func (c *context) NoContent(code int) error {
+	c.w.WriteHeader(code)
+	return nil
+}
+
+// 重定向到指定的 url，状态码只能是 300 ~ 303、307 或 308
+//
+// 304、305、306 不是重定向，使用时返回 ErrInvalidRedirectCode
+func (c *context) Redirect(code int, url string) error {
+	switch code {
+	case http.StatusMultipleChoices, http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return ErrInvalidRedirectCode
+	}
+	c.w.Header().Set(LOCATION, url)
+	c.w.WriteHeader(code)
+	return nil
+}
+
+// 发送指定类型的二进制数据
+func (c *context) Blob(code int, contentType string, b []byte) error {
+	c.w.Header().Set(CONTENT_TYPE, contentType)
+	c.w.WriteHeader(code)
+	_, err := c.w.Write(b)
+	return err
+}
+
+// 将 reader 中的内容以流的方式发送
+func (c *context) Stream(code int, contentType string, r io.Reader) error {
+	c.w.Header().Set(CONTENT_TYPE, contentType)
+	c.w.WriteHeader(code)
+	_, err := io.Copy(c.w, r)
+	return err
+}
+
+// 发送带缩进的JSON格式的文件
+func (c *context) JSONPretty(code int, data interface{}, indent string) error {
+	b, err := c.capa.marshalJSON(data, indent)
+	if err != nil {
+		return err
+	}
+	return c.JSONBlob(code, b)
+}
+
+// 发送 JSONP 格式的文件，callback 不是合法的标识符时返回 ErrInvalidCallback
+//
+//	callback({"id":1});
+func (c *context) JSONP(code int, callback string, data interface{}) error {
+	// callback 通常来自查询参数，不校验会成为脚本注入点
+	if !jsonpCallbackPattern.MatchString(callback) {
+		return ErrInvalidCallback
+	}
+	b, err := c.capa.marshalJSON(data, "")
+	if err != nil {
+		return err
+	}
+	// 去掉编码器在末尾追加的换行
+	b = bytes.TrimSuffix(b, []byte("\n"))
+	c.w.Header().Set(CONTENT_TYPE, APPLICATION_JAVASCRIPT)
+	c.w.Header().Set(X_CONTENT_TYPE_OPTIONS, "nosniff")
+	c.w.WriteHeader(code)
+	if _, err = c.w.Write([]byte(callback + "(")); err != nil {
+		return err
+	}
+	if _, err = c.w.Write(b); err != nil {
+		return err
+	}
+	_, err = c.w.Write([]byte(");"))
+	return err
+}
+
+// 发送已经编码好的JSON
+func (c *context) JSONBlob(code int, b []byte) error {
+	return c.Blob(code, APPLICATION_JSON, b)
+}
+
+// 发送已经编码好的XML
+func (c *context) XMLBlob(code int, b []byte) error {
+	c.w.Header().Set(CONTENT_TYPE, APPLICATION_XML)
+	c.w.WriteHeader(code)
+	if _, err := c.w.Write([]byte(xml.Header)); err != nil {
+		return err
+	}
+	_, err := c.w.Write(b)
+	return err
+}
+
+// 以附件的形式下载文件，name 为浏览器保存时使用的文件名
+func (c *context) Attachment(file string, name string) error {
+	return c.contentDisposition(file, name, "attachment")
+}
+
+// 以内联的形式在浏览器中打开文件
+func (c *context) Inline(file string, name string) error {
+	return c.contentDisposition(file, name, "inline")
+}
+
+func (c *context) contentDisposition(file string, name string, dispositionType string) error {
+	c.w.Header().Set(CONTENT_DISPOSITION, formatContentDisposition(dispositionType, name))
+	if err := c.File(file); err != nil {
+		// 错误响应不是附件
+		c.w.Header().Del(CONTENT_DISPOSITION)
+		return err
+	}
+	return nil
+}
+
 // 获取一个 路由中的某个指定的参数
 //
 //	例如：
@@ -125,7 +304,7 @@ func (c *context) HTML(code int, html string) error {
 // id ： 123
 // post_id : 456
 func (c *context) Param(name string) string {
-
+	c.assertAlive()
 	if _, ok := c.params[name]; !ok {
 		return ""
 	}
@@ -136,40 +315,92 @@ func (c *context) Param(name string) string {
 //
 // /user/:id/post/:post_id
 func (c *context) Path() string {
+	c.assertAlive()
 	return c.path
 }
 
 // 获取路由函数
 func (c *context) Handler() HandlerFunc {
+	c.assertAlive()
 	return c.handler
 }
 
 // 返回Cookie
 func (c *context) Cookie(name string) (*http.Cookie, error) {
+	c.assertAlive()
 	return c.r.Cookie(name)
 }
 
 func (c *context) Cookies() []*http.Cookie {
+	c.assertAlive()
 	return c.r.Cookies()
 }
 
 func (c *context) Request() *http.Request {
+	c.assertAlive()
 	return c.r
 }
 
+func (c *context) Response() *Response {
+	c.assertAlive()
+	return &c.resp
+}
+
+// 客户端断开或超时后关闭
+func (c *context) Done() <-chan struct{} {
+	c.assertAlive()
+	return c.r.Context().Done()
+}
+
+func (c *context) Deadline() (time.Time, bool) {
+	c.assertAlive()
+	return c.r.Context().Deadline()
+}
+
+func (c *context) Err() error {
+	c.assertAlive()
+	return c.r.Context().Err()
+}
+
+// 字符串类型的 key 先从 Set 保存的数据中查找，Key[T] 从带类型的数据中查找，其余交给请求的 context
+func (c *context) Value(key interface{}) interface{} {
+	c.assertAlive()
+	if k, ok := key.(string); ok {
+		if v, exists := c.data[k]; exists {
+			return v
+		}
+	} else if v, exists := c.values[key]; exists {
+		return v
+	}
+	return c.r.Context().Value(key)
+}
+
+// 替换请求的 context，例如设置更短的超时
+func (c *context) SetContext(ctx gocontext.Context) {
+	c.assertAlive()
+	c.r = c.r.WithContext(ctx)
+}
+
 func (c *context) GetHeader(key string) string {
+	c.assertAlive()
 	return c.r.Header.Get(key)
 }
 
 func (c *context) Get(key string) interface{} {
+	c.assertAlive()
 	return c.data[key]
 }
 
 func (c *context) Set(key string, value interface{}) {
+	c.assertAlive()
+	if c.data == nil {
+		c.data = make(map[string]interface{})
+	}
 	c.data[key] = value
 }
 
 func (c *context) Bind(data interface{}) (err error) {
+	c.assertAlive()
 	// 读取请求体
 	body, err := io.ReadAll(c.r.Body)
 	if err != nil {
@@ -182,11 +413,7 @@ func (c *context) Bind(data interface{}) (err error) {
 		return errors.New("request body is empty")
 	}
 
-	err = json.Unmarshal(body, data)
-	if err != nil {
-		return err
-	}
-	return err
+	return c.capa.jsonSerializer().Deserialize(bytes.NewReader(body), data)
 }
 
 // 是否 TLS 加密连接
@@ -200,17 +427,88 @@ func (c *context) IsWebSocket() bool {
 	return strings.EqualFold(upgrade, "websocket")
 }
 
-// TODO 解决这个方法的用途
+// 获取客户端的真实 IP
+//
+// 只有直接连接方是受信任的代理时才会读取 Forwarded / X-Forwarded-For / X-Real-Ip，
+// 并从右往左跳过受信任的代理，返回第一个不受信任的地址
 func (c *context) RealIP() string {
-	xForwardedFor := c.Request().Header.Get("X-Forwarded-For")
-	if xForwardedFor != "" {
-		// 按逗号分割IP列表
-		ips := strings.Split(xForwardedFor, ",")
-		for i := range ips {
-			ips[i] = strings.TrimSpace(ips[i])
+	remote := remoteIP(c.r)
+	if !c.fromTrustedProxy() {
+		return remote
+	}
+	ips := c.forwardedFor()
+	for i := len(ips) - 1; i >= 0; i-- {
+		if net.ParseIP(ips[i]) == nil {
+			continue
+		}
+		if !c.capa.isTrustedProxy(ips[i]) {
+			return ips[i]
+		}
+	}
+	// 代理链上全部是受信任的代理，返回最靠近客户端的那一个
+	for _, ip := range ips {
+		if net.ParseIP(ip) != nil {
+			return ip
+		}
+	}
+	return remote
+}
+
+// 获取请求的协议：http 或 https
+//
+// 受信任的代理可以通过 Forwarded 的 proto、X-Forwarded-Proto 或 X-Forwarded-Ssl 指定协议，
+// 指定的协议不是 http 或 https 时忽略，按连接本身是否为 TLS 判断
+func (c *context) Scheme() string {
+	if c.fromTrustedProxy() {
+		proto := c.forwardedValue("proto")
+		if proto == "" {
+			proto = c.xForwardedValue(HEADER_X_FORWARDED_PROTO)
 		}
+		if proto == "" && strings.EqualFold(c.xForwardedValue(HEADER_X_FORWARDED_SSL), "on") {
+			proto = "https"
+		}
+		if proto = strings.ToLower(proto); proto == "http" || proto == "https" {
+			return proto
+		}
+	}
+	if c.IsTLS() {
+		return "https"
+	}
+	return "http"
+}
+
+// 获取请求的主机名（可能带端口）
+//
+// 受信任的代理可以通过 Forwarded 的 host 或 X-Forwarded-Host 指定主机名，
+// 与 RealIP 一样只采信受信任的代理追加的值，格式不合法时忽略
+func (c *context) Host() string {
+	if c.fromTrustedProxy() {
+		host := c.forwardedValue("host")
+		if host == "" {
+			host = c.xForwardedValue(HEADER_X_FORWARDED_HOST)
+		}
+		if validHost(host) {
+			return host
+		}
+	}
+	return c.r.Host
+}
+
+// 获取请求的根地址
+//
+//	https://example.com
+func (c *context) BaseURL() string {
+	return c.Scheme() + "://" + c.Host()
+}
+
+// 根据当前请求生成一个完整的 URL，已经是完整 URL 的直接返回
+//
+//	c.AbsoluteURL("/user/123") => https://example.com/user/123
+func (c *context) AbsoluteURL(path string) string {
+	if u, err := url.Parse(path); err == nil && u.IsAbs() {
+		return path
 	}
-	return ""
+	return joinPath(c.BaseURL(), path)
 }
 
 // 设置路由处理函数
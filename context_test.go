@@ -0,0 +1,324 @@
+package capybara
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 构造一个指向当前实例的 context
+func newTestContext(c *capybara, r *http.Request) (*context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	ctx := new(context)
+	ctx.Reset()
+	ctx.ApplyContext(c, map[string]string{}, w, r)
+	return ctx, w
+}
+
+// 测试受信任代理下的真实 IP
+func TestRealIP(t *testing.T) {
+	c := CreateCapybaraInstance()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set(HEADER_X_FORWARDED_FOR, "203.0.113.7, 10.0.0.2")
+	ctx, _ := newTestContext(c, r)
+	if ip := ctx.RealIP(); ip != "203.0.113.7" {
+		t.Errorf("X-Forwarded-For 解析失败: %s", ip)
+	}
+
+	r.Header.Del(HEADER_X_FORWARDED_FOR)
+	r.Header.Set(HEADER_FORWARDED, `for="[2001:db8::1]:4711";proto=https, for=10.0.0.2`)
+	if ip := ctx.RealIP(); ip != "2001:db8::1" {
+		t.Errorf("Forwarded 解析失败: %s", ip)
+	}
+
+	// 不受信任的连接方不能伪造 IP
+	r.RemoteAddr = "198.51.100.1:1234"
+	if ip := ctx.RealIP(); ip != "198.51.100.1" {
+		t.Errorf("不受信任的代理被采信: %s", ip)
+	}
+}
+
+// 测试代理下的协议与主机名
+func TestSchemeAndHost(t *testing.T) {
+	c := CreateCapybaraInstance()
+
+	r := httptest.NewRequest("GET", "http://internal:8080/", nil)
+	r.RemoteAddr = "127.0.0.1:1234"
+	r.Header.Set(HEADER_X_FORWARDED_PROTO, "https")
+	r.Header.Set(HEADER_X_FORWARDED_HOST, "example.com")
+	ctx, _ := newTestContext(c, r)
+	if u := ctx.AbsoluteURL("/user/1"); u != "https://example.com/user/1" {
+		t.Errorf("完整 URL 生成失败: %s", u)
+	}
+
+	r.Header.Set(HEADER_FORWARDED, "proto=http;host=api.example.com")
+	if u := ctx.BaseURL(); u != "http://api.example.com" {
+		t.Errorf("Forwarded 优先级异常: %s", u)
+	}
+
+	r.Header.Set(HEADER_FORWARDED, "proto=javascript;host=api.example.com")
+	if s := ctx.Scheme(); s != "http" {
+		t.Errorf("非法的代理协议被采信: %s", s)
+	}
+	r.Header.Del(HEADER_FORWARDED)
+	r.Header.Set(HEADER_X_FORWARDED_PROTO, "HTTPS")
+	if s := ctx.Scheme(); s != "https" {
+		t.Errorf("代理协议大小写处理异常: %s", s)
+	}
+	r.Header.Set(HEADER_X_FORWARDED_PROTO, "ftp")
+	if s := ctx.Scheme(); s != "http" {
+		t.Errorf("非法的代理协议被采信: %s", s)
+	}
+	r.Header.Set(HEADER_FORWARDED, "proto=http;host=api.example.com")
+
+	c.SetTrustedProxies()
+	if u := ctx.BaseURL(); u != "http://internal:8080" {
+		t.Errorf("未信任代理时仍读取了代理请求头: %s", u)
+	}
+	if u := ctx.AbsoluteURL("https://other.com/x"); u != "https://other.com/x" {
+		t.Errorf("完整 URL 被改写: %s", u)
+	}
+}
+
+// 测试受信任的代理追加请求头时，客户端无法伪造主机名与协议
+func TestSchemeAndHostSpoofing(t *testing.T) {
+	c := CreateCapybaraInstance()
+	r := httptest.NewRequest("GET", "http://internal:8080/", nil)
+	r.RemoteAddr = "10.0.0.2:1234"
+	// 客户端发送的值在左边，代理追加的值在右边
+	r.Header.Add(HEADER_X_FORWARDED_HOST, "evil.com")
+	r.Header.Add(HEADER_X_FORWARDED_HOST, "example.com")
+	r.Header.Set(HEADER_X_FORWARDED_PROTO, "http, https")
+	r.Header.Set(HEADER_X_FORWARDED_FOR, "198.51.100.1")
+	ctx, _ := newTestContext(c, r)
+	if u := ctx.BaseURL(); u != "https://example.com" {
+		t.Errorf("采信了客户端伪造的请求头: %s", u)
+	}
+
+	// 两层受信任的代理，取最外层代理追加的值
+	r.Header.Set(HEADER_X_FORWARDED_HOST, "evil.com, example.com, internal-lb")
+	r.Header.Set(HEADER_X_FORWARDED_FOR, "198.51.100.1, 10.0.0.1")
+	if h := ctx.Host(); h != "example.com" {
+		t.Errorf("多层代理的主机名异常: %s", h)
+	}
+
+	r.Header.Set(HEADER_FORWARDED, `host=evil.com;proto=http, for=198.51.100.1;host=api.example.com;proto=https`)
+	if u := ctx.BaseURL(); u != "https://api.example.com" {
+		t.Errorf("采信了客户端伪造的 Forwarded: %s", u)
+	}
+
+	r.Header.Set(HEADER_FORWARDED, "for=198.51.100.1;host=evil.com/x@y")
+	if h := ctx.Host(); h != "internal:8080" {
+		t.Errorf("非法的主机名被采信: %s", h)
+	}
+}
+
+// 测试重定向状态码校验
+func TestRedirect(t *testing.T) {
+	ctx, w := newTestContext(CreateCapybaraInstance(), httptest.NewRequest("GET", "/", nil))
+	for _, code := range []int{200, 304, 305, 306, 309} {
+		if err := ctx.Redirect(code, "/login"); err != ErrInvalidRedirectCode {
+			t.Errorf("非法重定向状态码 %d 未报错", code)
+		}
+	}
+	if err := ctx.Redirect(302, "/login"); err != nil || w.Code != 302 || w.Header().Get(LOCATION) != "/login" {
+		t.Error("重定向失败")
+	}
+}
+
+// 测试 JSONP 响应
+func TestJSONP(t *testing.T) {
+	ctx, w := newTestContext(CreateCapybaraInstance(), httptest.NewRequest("GET", "/", nil))
+	ctx.JSONP(200, "cb", map[string]int{"id": 1})
+	if w.Body.String() != `cb({"id":1});` || w.Header().Get(CONTENT_TYPE) != APPLICATION_JAVASCRIPT {
+		t.Errorf("JSONP 响应异常: %s", w.Body.String())
+	}
+	if w.Header().Get(X_CONTENT_TYPE_OPTIONS) != "nosniff" {
+		t.Error("JSONP 响应缺少 nosniff")
+	}
+}
+
+// 测试 JSONP 回调名校验
+func TestJSONPCallback(t *testing.T) {
+	testCases := []struct {
+		callback string
+		valid    bool
+	}{
+		{"cb", true},
+		{"$_cb1", true},
+		{"jQuery.cb", true},
+		{"a.b.c", true},
+		{"", false},
+		{"1cb", false},
+		{"a..b", false},
+		{"alert(1);cb", false},
+		{"cb</script>", false},
+	}
+	for _, tc := range testCases {
+		ctx, w := newTestContext(CreateCapybaraInstance(), httptest.NewRequest("GET", "/", nil))
+		err := ctx.JSONP(200, tc.callback, 1)
+		if tc.valid && err != nil {
+			t.Errorf("合法回调名 %q 被拒绝: %v", tc.callback, err)
+		}
+		if !tc.valid && (err != ErrInvalidCallback || w.Body.Len() != 0) {
+			t.Errorf("非法回调名 %q 未被拒绝", tc.callback)
+		}
+	}
+}
+
+// 测试 Content-Disposition 编码
+func TestContentDisposition(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expected string
+	}{
+		{"report.pdf", `attachment; filename="report.pdf"`},
+		{`a"b.txt`, `attachment; filename="a\"b.txt"`},
+		{"你好.txt", `attachment; filename="__.txt"; filename*=UTF-8''%E4%BD%A0%E5%A5%BD.txt`},
+	}
+	for _, tc := range testCases {
+		if result := formatContentDisposition("attachment", tc.name); result != tc.expected {
+			t.Errorf("Content-Disposition 编码错误: 输入 %s 期望 %s 得到 %s", tc.name, tc.expected, result)
+		}
+	}
+}
+
+// 测试替换 JSON 序列化器的选项
+func TestJSONSerializer(t *testing.T) {
+	c := CreateCapybaraInstance()
+	c.JSONSerializer = &DefaultJSONSerializer{DisallowUnknownFields: true, DisableHTMLEscape: true}
+
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"name":"a","age":1}`))
+	ctx, w := newTestContext(c, r)
+	var data struct {
+		Name string `json:"name"`
+	}
+	if err := ctx.Bind(&data); err == nil {
+		t.Error("严格解码未拒绝未知字段")
+	}
+	// 请求体中只能有一个 JSON 值，末尾的空白除外
+	for body, expected := range map[string]error{
+		`{"name":"a"}{"name":"b"}`: ErrTrailingData,
+		`{"name":"a"} garbage`:     ErrTrailingData,
+		"{\"name\":\"a\"}\n":       nil,
+	} {
+		ctx, _ := newTestContext(CreateCapybaraInstance(), httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		if err := ctx.Bind(&data); err != expected {
+			t.Errorf("多余数据的处理异常: %q %v", body, err)
+		}
+	}
+
+	ctx.JSON(200, map[string]string{"html": "<b>"})
+	if w.Body.String() != "{\"html\":\"<b>\"}\n" {
+		t.Errorf("HTML 转义选项未生效: %s", w.Body.String())
+	}
+}
+
+// 测试 Context 作为 context.Context 使用
+func TestContextCancellation(t *testing.T) {
+	c := CreateCapybaraInstance()
+	c.GET("/slow", func(ctx Context) {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("路由超时未设置 Deadline")
+		}
+		ctx.Set("user", "capy")
+		if ctx.Value("user") != "capy" {
+			t.Error("Value 未读取 Set 保存的数据")
+		}
+		<-ctx.Done()
+		if ctx.Err() == nil {
+			t.Error("超时后 Err 为空")
+		}
+	}, Timeout(10*time.Millisecond))
+	c.GET("/fast", func(ctx Context) {
+		ctx.String(200, "ok")
+	}, Timeout(time.Second))
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("GET", "/slow", nil))
+	if w.Code != 503 {
+		t.Errorf("超时未返回 503: %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("GET", "/fast", nil))
+	if w.Code != 200 || w.Body.String() != "ok" {
+		t.Errorf("未超时的请求异常: %d", w.Code)
+	}
+}
+
+// 测试复制出的 Context 在请求结束后仍然可用
+func TestContextCopy(t *testing.T) {
+	c := CreateCapybaraInstance()
+	var cp Context
+	c.GET("/user/:id", func(ctx Context) {
+		ctx.Set("user", "capy")
+		cp = ctx.Copy()
+	})
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/user/7", nil))
+
+	if cp.Param("id") != "7" || cp.Get("user") != "capy" || cp.Path() != "/user/:id" {
+		t.Error("复制的 Context 数据丢失")
+	}
+	if cp.Err() != nil {
+		t.Error("复制的 Context 随请求结束被取消")
+	}
+	if err := cp.String(200, "late"); err != ErrResponseDetached {
+		t.Error("复制的 Context 仍可写出响应")
+	}
+}
+
+// 测试调试模式下检测请求结束后的使用
+func TestContextUseAfterRelease(t *testing.T) {
+	c := CreateCapybaraInstance()
+	c.Debug = true
+	var leaked Context
+	c.GET("/", func(ctx Context) {
+		leaked = ctx
+	})
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	for name, use := range map[string]func(){
+		"Param":  func() { leaked.Param("id") },
+		"String": func() { leaked.String(200, "x") },
+	} {
+		func() {
+			defer func() {
+				if err := recover(); err != releasedContextMessage {
+					t.Errorf("%s 未检测到请求结束后的使用: %v", name, err)
+				}
+			}()
+			use()
+		}()
+	}
+}
+
+// 测试带类型的数据键
+func TestTypedKey(t *testing.T) {
+	userKey := NewKey[string]("user")
+	otherKey := NewKey[string]("user")
+	countKey := NewKey[int]("count")
+
+	ctx, _ := newTestContext(CreateCapybaraInstance(), httptest.NewRequest("GET", "/", nil))
+	if ctx.data != nil {
+		t.Error("数据未在第一次使用时才分配")
+	}
+	userKey.With(ctx, "capy")
+	countKey.With(ctx, 3)
+
+	if user, ok := userKey.Value(ctx); !ok || user != "capy" {
+		t.Error("带类型的数据读取失败")
+	}
+	if _, ok := otherKey.Value(ctx); ok {
+		t.Error("同名的不同键发生冲突")
+	}
+	if count, _ := countKey.Value(ctx.Copy()); count != 3 {
+		t.Error("复制的 Context 丢失带类型的数据")
+	}
+	if ctx.data != nil {
+		t.Error("带类型的数据占用了 Set 的数据")
+	}
+}
@@ -0,0 +1,102 @@
+package capybara
+
+import (
+	gocontext "context"
+	"errors"
+	"net/http"
+)
+
+var ErrResponseDetached = errors.New("response is detached from a copied context")
+
+const releasedContextMessage = "capybara: Context used after the request finished; use Context.Copy() to pass it to a goroutine"
+
+// 复制一份与当前请求脱离的 Context，可以安全地在 goroutine 中使用
+//
+// 复制出的 Context 保留路由参数、Set 保存的数据和请求信息，请求的 context 不会随请求结束而取消，
+// 但不能再写出响应：
+//
+//	cp := ctx.Copy()
+//	go func() {
+//		audit(cp, cp.Param("id"), cp.Get("user"))
+//	}()
+func (c *context) Copy() Context {
+	c.assertAlive()
+	cp := &context{
+		capa:    c.capa,
+		r:       c.r.WithContext(gocontext.WithoutCancel(c.r.Context())),
+		params:  make(map[string]string, len(c.params)),
+		path:    c.path,
+		handler: c.handler,
+		logger:  c.logger,
+	}
+	cp.resp.reset(&detachedResponseWriter{header: c.resp.Header().Clone()})
+	cp.resp.Status = c.resp.Status
+	cp.resp.Size = c.resp.Size
+	cp.resp.Committed = c.resp.Committed
+	cp.w = &cp.resp
+	for k, v := range c.params {
+		cp.params[k] = v
+	}
+	if c.data != nil {
+		cp.data = make(map[string]interface{}, len(c.data))
+		for k, v := range c.data {
+			cp.data[k] = v
+		}
+	}
+	if c.values != nil {
+		cp.values = make(map[interface{}]interface{}, len(c.values))
+		for k, v := range c.values {
+			cp.values[k] = v
+		}
+	}
+	return cp
+}
+
+// 复制出的 Context 使用的 ResponseWriter，所有写入都会失败
+type detachedResponseWriter struct {
+	header http.Header
+}
+
+func (w *detachedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *detachedResponseWriter) Write(b []byte) (int, error) {
+	return 0, ErrResponseDetached
+}
+
+func (w *detachedResponseWriter) WriteHeader(code int) {}
+
+// 请求结束后归还 Context
+//
+// 调试模式下不放回池中，而是标记为已释放，之后的任何使用都会 panic，便于发现在 goroutine 中误用
+func (c *capybara) releaseContext(ctx *context) {
+	if c.Debug {
+		ctx.released = true
+		ctx.resp.reset(releasedResponseWriter{})
+		return
+	}
+	c.pool.Put(ctx)
+}
+
+// 已释放的 Context 被使用时 panic
+func (c *context) assertAlive() {
+	if c.released {
+		panic(releasedContextMessage)
+	}
+}
+
+// 已释放的 Context 使用的 ResponseWriter，任何写入都会 panic
+type releasedResponseWriter struct{}
+
+func (releasedResponseWriter) Header() http.Header {
+	panic(releasedContextMessage)
+}
+
+func (releasedResponseWriter) Write(b []byte) (int, error) {
+	panic(releasedContextMessage)
+}
+
+func (releasedResponseWriter) WriteHeader(code int) {
+	panic(releasedContextMessage)
+}
@@ -0,0 +1,72 @@
+package capybara
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// 带状态码的错误，交给集中的错误处理函数生成响应
+//
+//	return capybara.NewHTTPError(404, "user not found")
+type HTTPError struct {
+	Code    int
+	Message interface{}
+	// 内部错误，只用于日志，不会发送给客户端
+	Internal error
+}
+
+// 创建一个 HTTPError，不传 message 时使用状态码的标准描述
+func NewHTTPError(code int, message ...interface{}) *HTTPError {
+	he := &HTTPError{Code: code, Message: http.StatusText(code)}
+	if len(message) > 0 {
+		he.Message = message[0]
+	}
+	return he
+}
+
+func (he *HTTPError) Error() string {
+	if he.Internal == nil {
+		return fmt.Sprintf("code=%d, message=%v", he.Code, he.Message)
+	}
+	return fmt.Sprintf("code=%d, message=%v, internal=%v", he.Code, he.Message, he.Internal)
+}
+
+// 设置内部错误
+func (he *HTTPError) SetInternal(err error) *HTTPError {
+	he.Internal = err
+	return he
+}
+
+func (he *HTTPError) Unwrap() error {
+	return he.Internal
+}
+
+// 集中的错误处理函数
+type HTTPErrorHandler func(err error, c Context)
+
+// 默认的错误处理：以 RFC 9457 Problem Details 的格式发送
+//
+// HTTPError 按其状态码发送，Problem 原样发送，其余错误发送 500
+func (c *capybara) DefaultHTTPErrorHandler(err error, ctx Context) {
+	p := c.problemOf(err)
+	if p.Status == 0 || p.Status >= http.StatusInternalServerError {
+		ctx.Logger().Error("request failed", "error", err, "status", p.Status, "path", ctx.Request().URL.Path)
+	}
+	// 响应已经写出时无法再修改
+	if ctx.Response().Committed {
+		return
+	}
+	c.sendProblem(ctx.Response(), ctx.Request(), p)
+}
+
+// 把错误交给实例的集中错误处理函数
+func (c *context) Error(err error) {
+	if err == nil {
+		return
+	}
+	if c.capa == nil || c.capa.HTTPErrorHandler == nil {
+		c.capa.sendProblem(c.w, c.r, c.capa.problemOf(err))
+		return
+	}
+	c.capa.HTTPErrorHandler(err, c)
+}
@@ -0,0 +1,123 @@
+package capybara
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ETAG       = "ETag"
+	INDEX_HTML = "index.html"
+)
+
+var ErrFileNotFound = errors.New("file not found")
+
+// 发送本地文件
+//
+// 支持 Range（包括 multipart/byteranges）、If-Modified-Since、If-None-Match、
+// 根据扩展名或内容推断 Content-Type 以及 HEAD 请求，目录会尝试发送其中的 index.html
+//
+// 出错时不写响应，返回的 *HTTPError 交给 Context.Error 发送
+//
+//	if err := ctx.File("./report.pdf"); err != nil {
+//		ctx.Error(err)
+//	}
+func (c *context) File(file string) error {
+	dir, name := filepath.Split(filepath.Clean(file))
+	if dir == "" {
+		dir = "."
+	}
+	return c.FileFS(os.DirFS(dir), name)
+}
+
+// 发送 fs.FS 中的文件，可以配合 embed.FS 使用
+func (c *context) FileFS(fsys fs.FS, name string) error {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		name = "."
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		return c.fileError(err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return c.fileError(err)
+	}
+	if info.IsDir() {
+		index, err := fsys.Open(path.Join(name, INDEX_HTML))
+		if err != nil {
+			return c.fileError(err)
+		}
+		defer index.Close()
+		if info, err = index.Stat(); err != nil {
+			return c.fileError(err)
+		}
+		f = index
+	}
+	return c.serveContent(info, f)
+}
+
+// 在已有的响应路径上发送文件内容，Range 与条件请求交给 http.ServeContent 处理
+func (c *context) serveContent(info fs.FileInfo, f fs.File) error {
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		// 部分 fs.FS 的实现不支持 Seek，只能读入内存
+		b, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		content = bytes.NewReader(b)
+	}
+	if c.w.Header().Get(ETAG) == "" {
+		etag, err := fileETag(info, content)
+		if err != nil {
+			return err
+		}
+		c.w.Header().Set(ETAG, etag)
+	}
+	http.ServeContent(c.w, c.r, info.Name(), info.ModTime(), content)
+	return nil
+}
+
+// 强校验的 ETag，If-Range 只接受强校验的 ETag
+//
+// embed.FS 等没有修改时间的文件使用内容的哈希，其余使用修改时间与大小
+func fileETag(info fs.FileInfo, content io.ReadSeeker) (string, error) {
+	if !info.ModTime().IsZero() {
+		return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()), nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`"%x"`, h.Sum(nil)[:16]), nil
+}
+
+// 把打开文件的错误转换成 HTTPError，由调用方交给 Context.Error 统一发送响应
+//
+// 文件不存在时为 404，权限不足时为 403，其余为 500；原始错误保存在 Internal 中，
+// 文件不存在时可以用 errors.Is(err, ErrFileNotFound) 判断
+func (c *context) fileError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrInvalid) {
+		return NewHTTPError(http.StatusNotFound).SetInternal(ErrFileNotFound)
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		return NewHTTPError(http.StatusForbidden).SetInternal(err)
+	}
+	return NewHTTPError(http.StatusInternalServerError).SetInternal(err)
+}
@@ -0,0 +1,109 @@
+package capybara
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var testFS = fstest.MapFS{
+	"video.mp4":         {Data: []byte("0123456789"), ModTime: time.Unix(1700000000, 0)},
+	"docs/index.html":   {Data: []byte("<h1>docs</h1>")},
+	"assets/readme.txt": {Data: []byte("hello")},
+}
+
+// 测试 Range 请求
+func TestFileFSRange(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Range", "bytes=2-5")
+	ctx, w := newTestContext(CreateCapybaraInstance(), r)
+	ctx.FileFS(testFS, "video.mp4")
+	if w.Code != 206 || w.Body.String() != "2345" {
+		t.Errorf("Range 请求异常: %d %s", w.Code, w.Body.String())
+	}
+}
+
+// 测试 If-Range 使用 ETag 继续下载
+func TestFileFSIfRange(t *testing.T) {
+	for _, name := range []string{"video.mp4", "assets/readme.txt"} {
+		ctx, w := newTestContext(CreateCapybaraInstance(), httptest.NewRequest("GET", "/", nil))
+		ctx.FileFS(testFS, name)
+		etag := w.Header().Get(ETAG)
+		if strings.HasPrefix(etag, "W/") {
+			t.Fatalf("ETag 不是强校验: %s", etag)
+		}
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Range", "bytes=1-2")
+		r.Header.Set("If-Range", etag)
+		ctx, w = newTestContext(CreateCapybaraInstance(), r)
+		ctx.FileFS(testFS, name)
+		if w.Code != 206 || w.Body.Len() != 2 {
+			t.Errorf("%s 的 If-Range 没有生效: %d", name, w.Code)
+		}
+	}
+}
+
+// 测试没有修改时间的文件按内容生成 ETag
+func TestFileFSContentETag(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt": {Data: []byte("aaaa")},
+		"b.txt": {Data: []byte("bbbb")},
+	}
+	etags := make(map[string]bool)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		ctx, w := newTestContext(CreateCapybaraInstance(), httptest.NewRequest("GET", "/", nil))
+		ctx.FileFS(fsys, name)
+		if w.Body.Len() != 4 {
+			t.Errorf("计算哈希后内容不完整: %q", w.Body.String())
+		}
+		etags[w.Header().Get(ETAG)] = true
+	}
+	if len(etags) != 2 {
+		t.Errorf("内容不同的文件 ETag 相同: %v", etags)
+	}
+}
+
+// 测试条件请求
+func TestFileFSConditional(t *testing.T) {
+	ctx, w := newTestContext(CreateCapybaraInstance(), httptest.NewRequest("GET", "/", nil))
+	ctx.FileFS(testFS, "video.mp4")
+	etag := w.Header().Get(ETAG)
+	if etag == "" {
+		t.Fatal("未生成 ETag")
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("If-None-Match", etag)
+	ctx, w = newTestContext(CreateCapybaraInstance(), r)
+	ctx.FileFS(testFS, "video.mp4")
+	if w.Code != 304 {
+		t.Errorf("If-None-Match 未生效: %d", w.Code)
+	}
+}
+
+// 测试目录首页、HEAD 请求与文件不存在
+func TestFileFSIndexAndNotFound(t *testing.T) {
+	ctx, w := newTestContext(CreateCapybaraInstance(), httptest.NewRequest("HEAD", "/", nil))
+	ctx.FileFS(testFS, "/docs/")
+	if w.Code != 200 || w.Body.Len() != 0 || w.Header().Get(CONTENT_TYPE) != "text/html; charset=utf-8" {
+		t.Errorf("目录首页或 HEAD 请求异常: %d %s", w.Code, w.Header().Get(CONTENT_TYPE))
+	}
+
+	ctx, w = newTestContext(CreateCapybaraInstance(), httptest.NewRequest("GET", "/", nil))
+	err := ctx.FileFS(testFS, "missing.txt")
+	var he *HTTPError
+	if !errors.As(err, &he) || he.Code != 404 || !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("文件不存在时返回的错误异常: %v", err)
+	}
+	if w.Body.Len() != 0 {
+		t.Error("文件不存在时不应直接写出响应")
+	}
+	ctx.Error(err)
+	if w.Code != 404 {
+		t.Errorf("文件不存在时状态码异常: %d", w.Code)
+	}
+}
@@ -0,0 +1,138 @@
+package capybara
+
+import (
+	"encoding/xml"
+	"errors"
+	"mime"
+	"net/http"
+	"reflect"
+)
+
+// 校验器，可以接入任意的校验库
+type Validator interface {
+	Validate(data interface{}) error
+}
+
+// 可以自行校验的请求
+type selfValidator interface {
+	Validate() error
+}
+
+// 指定响应状态码的返回值，没有实现时使用 200
+type StatusCoder interface {
+	StatusCode() int
+}
+
+// 带类型的路由函数
+type TypedHandlerFunc[Req any, Resp any] func(c Context, req Req) (Resp, error)
+
+// 将带类型的路由函数转换为 HandlerFunc
+//
+// 请求依次从请求体（JSON 或 XML）、查询参数（query 标签）和路由参数（param 标签）绑定到 Req，
+// 校验后调用 fn，返回值通过内容协商发送；任何错误都交给集中的错误处理函数：
+//
+//	type GetUser struct {
+//		ID int `param:"id"`
+//	}
+//
+//	c.GET("/users/:id", capybara.Handle(func(c capybara.Context, req GetUser) (*User, error) {
+//		return users.Find(c, req.ID)
+//	}))
+func Handle[Req any, Resp any](fn TypedHandlerFunc[Req, Resp]) HandlerFunc {
+	return func(c Context) {
+		req, err := bindRequest[Req](c)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		if err := validateRequest(c, req); err != nil {
+			c.Error(err)
+			return
+		}
+		resp, err := fn(c, req)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		code := http.StatusOK
+		if sc, ok := interface{}(resp).(StatusCoder); ok {
+			code = sc.StatusCode()
+		}
+		if code == http.StatusNoContent {
+			c.NoContent(code)
+			return
+		}
+		if err := c.Negotiate(code, resp); err != nil {
+			c.Error(err)
+		}
+	}
+}
+
+func bindRequest[Req any](c Context) (Req, error) {
+	var req Req
+	target := interface{}(&req)
+	// Req 是指针类型时分配一个新的对象
+	if t := reflect.TypeOf(req); t != nil && t.Kind() == reflect.Pointer {
+		req = reflect.New(t.Elem()).Interface().(Req)
+		target = req
+	}
+
+	if err := bindBody(c, target); err != nil {
+		return req, err
+	}
+	if reflect.ValueOf(target).Elem().Kind() == reflect.Struct {
+		if err := c.BindQuery(target); err != nil {
+			return req, NewHTTPError(http.StatusBadRequest, err.Error()).SetInternal(err)
+		}
+		if err := c.BindPath(target); err != nil {
+			return req, NewHTTPError(http.StatusBadRequest, err.Error()).SetInternal(err)
+		}
+	}
+	return req, nil
+}
+
+// 有请求体时按照 Content-Type 解码
+func bindBody(c Context, target interface{}) error {
+	r := c.Request()
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get(CONTENT_TYPE))
+	var err error
+	switch mediaType {
+	case "", APPLICATION_JSON:
+		err = c.Bind(target)
+	case APPLICATION_XML, TEXT_XML:
+		defer r.Body.Close()
+		err = xml.NewDecoder(r.Body).Decode(target)
+	default:
+		return NewHTTPError(http.StatusUnsupportedMediaType)
+	}
+	if err != nil {
+		return NewHTTPError(http.StatusBadRequest, err.Error()).SetInternal(err)
+	}
+	return nil
+}
+
+// 先调用请求自身的 Validate，再调用实例上注册的校验器
+func validateRequest(c Context, req interface{}) error {
+	if v, ok := req.(selfValidator); ok {
+		if err := v.Validate(); err != nil {
+			return asBadRequest(err)
+		}
+	}
+	if capa := instanceOf(c); capa != nil && capa.Validator != nil {
+		if err := capa.Validator.Validate(req); err != nil {
+			return asBadRequest(err)
+		}
+	}
+	return nil
+}
+
+func asBadRequest(err error) error {
+	var he *HTTPError
+	if errors.As(err, &he) {
+		return err
+	}
+	return NewHTTPError(http.StatusBadRequest, err.Error()).SetInternal(err)
+}
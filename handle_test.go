@@ -0,0 +1,97 @@
+package capybara
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type updateUserReq struct {
+	ID     int      `param:"id"`
+	Notify bool     `query:"notify"`
+	Tags   []string `query:"tag"`
+	Name   string   `json:"name"`
+}
+
+func (r updateUserReq) Validate() error {
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+type updateUserResp struct {
+	ID     int      `json:"id"`
+	Name   string   `json:"name"`
+	Notify bool     `json:"notify"`
+	Tags   []string `json:"tags"`
+}
+
+func newHandleServer() *capybara {
+	c := CreateCapybaraInstance()
+	c.PUT("/users/:id", Handle(func(ctx Context, req updateUserReq) (updateUserResp, error) {
+		if req.ID == 404 {
+			return updateUserResp{}, NewHTTPError(404, "user not found")
+		}
+		return updateUserResp{req.ID, req.Name, req.Notify, req.Tags}, nil
+	}))
+	return c
+}
+
+func serveHandle(c *capybara, target string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("PUT", target, strings.NewReader(body))
+	r.Header.Set(CONTENT_TYPE, APPLICATION_JSON)
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, r)
+	return w
+}
+
+// 测试从路由参数、查询参数和请求体绑定
+func TestHandleBind(t *testing.T) {
+	w := serveHandle(newHandleServer(), "/users/7?notify=true&tag=a&tag=b", `{"name":"capy"}`)
+	expected := "{\"id\":7,\"name\":\"capy\",\"notify\":true,\"tags\":[\"a\",\"b\"]}\n"
+	if w.Code != 200 || w.Body.String() != expected {
+		t.Errorf("绑定结果异常: %d %s", w.Code, w.Body.String())
+	}
+}
+
+// 测试校验与错误处理
+func TestHandleErrors(t *testing.T) {
+	c := newHandleServer()
+	testCases := []struct {
+		target string
+		body   string
+		code   int
+	}{
+		{"/users/7", `{}`, 400},
+		{"/users/abc", `{"name":"capy"}`, 400},
+		{"/users/7", `{"name":`, 400},
+		{"/users/7", `{"name":"capy"}{"name":"evil"}`, 400},
+		{"/users/7", `{"name":"capy"} garbage`, 400},
+		{"/users/404", `{"name":"capy"}`, 404},
+	}
+	for _, tc := range testCases {
+		if w := serveHandle(c, tc.target, tc.body); w.Code != tc.code {
+			t.Errorf("错误处理异常: %s %s 期望 %d 得到 %d", tc.target, tc.body, tc.code, w.Code)
+		}
+	}
+}
+
+// 测试不可接受的响应类型交给集中的错误处理函数
+func TestHandleNotAcceptable(t *testing.T) {
+	c := newHandleServer()
+	var handled error
+	c.HTTPErrorHandler = func(err error, ctx Context) {
+		handled = err
+		c.DefaultHTTPErrorHandler(err, ctx)
+	}
+	r := httptest.NewRequest("PUT", "/users/7", strings.NewReader(`{"name":"capy"}`))
+	r.Header.Set(CONTENT_TYPE, APPLICATION_JSON)
+	r.Header.Set(ACCEPT, "text/html")
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, r)
+	if w.Code != 406 || !errors.Is(handled, ErrNotAcceptable) {
+		t.Errorf("406 没有交给错误处理函数: %d %v", w.Code, handled)
+	}
+}
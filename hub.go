@@ -0,0 +1,304 @@
+package capybara
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// 在线状态事件的类型
+const (
+	PRESENCE_JOIN  = "join"
+	PRESENCE_LEAVE = "leave"
+)
+
+const defaultHubSendQueueSize = 256
+
+var ErrSlowConsumer = errors.New("hub: slow consumer evicted")
+
+// 通过 Hub 发送的一条消息
+type HubMessage struct {
+	// WebSocket 的消息类型，为 0 时按文本消息发送
+	Type int
+	// SSE 的事件名
+	Event string
+	Data  []byte
+}
+
+// 连接加入或离开房间时产生的事件
+type PresenceEvent struct {
+	Type   string
+	Room   string
+	Client *HubClient
+}
+
+// Hub 的底层连接，WebSocket 与 SSE 都有对应的实现
+type HubTransport interface {
+	Send(msg HubMessage) error
+	// 因为发送失败或者消费太慢被踢出时调用
+	Close(reason error) error
+}
+
+// Hub 的配置
+type HubConfig struct {
+	// 每个连接的发送队列长度，队列满时该连接会被踢出，默认 256
+	SendQueueSize int
+	// 连接加入或离开房间时调用，不要在其中阻塞
+	OnPresence func(e PresenceEvent)
+}
+
+// 按房间广播消息的发布订阅中心
+//
+// 每个连接有自己的发送队列和发送 goroutine，广播不会被某个慢连接阻塞
+type Hub struct {
+	config  HubConfig
+	mu      sync.RWMutex
+	rooms   map[string]map[*HubClient]struct{}
+	clients map[*HubClient]struct{}
+}
+
+// 加入 Hub 的一个连接
+type HubClient struct {
+	ID        string
+	hub       *Hub
+	transport HubTransport
+	send      chan HubMessage
+	rooms     map[string]struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// 创建一个 Hub
+func NewHub(config HubConfig) *Hub {
+	if config.SendQueueSize <= 0 {
+		config.SendQueueSize = defaultHubSendQueueSize
+	}
+	return &Hub{
+		config:  config,
+		rooms:   make(map[string]map[*HubClient]struct{}),
+		clients: make(map[*HubClient]struct{}),
+	}
+}
+
+// 注册一个连接，并启动它的发送 goroutine
+func (h *Hub) Register(id string, transport HubTransport) *HubClient {
+	client := &HubClient{
+		ID:        id,
+		hub:       h,
+		transport: transport,
+		send:      make(chan HubMessage, h.config.SendQueueSize),
+		rooms:     make(map[string]struct{}),
+		done:      make(chan struct{}),
+	}
+	h.mu.Lock()
+	h.clients[client] = struct{}{}
+	h.mu.Unlock()
+	go client.writeLoop()
+	return client
+}
+
+// 注册一个 WebSocket 连接
+func (h *Hub) RegisterWebSocket(id string, conn *WebSocketConn) *HubClient {
+	return h.Register(id, &webSocketTransport{conn})
+}
+
+// 注册一个 SSE 连接，路由函数需要阻塞到 client.Done() 或 sse.Done() 关闭
+func (h *Hub) RegisterSSE(id string, sse *SSEWriter) *HubClient {
+	return h.Register(id, &sseTransport{sse})
+}
+
+// 向房间内的所有连接广播
+func (h *Hub) Broadcast(room string, msg HubMessage) {
+	h.mu.RLock()
+	slow := make([]*HubClient, 0)
+	for client := range h.rooms[room] {
+		if !client.enqueue(msg) {
+			slow = append(slow, client)
+		}
+	}
+	h.mu.RUnlock()
+	h.evict(slow)
+}
+
+// 向所有连接广播
+func (h *Hub) BroadcastAll(msg HubMessage) {
+	h.mu.RLock()
+	slow := make([]*HubClient, 0)
+	for client := range h.clients {
+		if !client.enqueue(msg) {
+			slow = append(slow, client)
+		}
+	}
+	h.mu.RUnlock()
+	h.evict(slow)
+}
+
+// 返回房间内所有连接的 ID
+func (h *Hub) Members(room string) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	ids := make([]string, 0, len(h.rooms[room]))
+	for client := range h.rooms[room] {
+		ids = append(ids, client.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// 踢出发送队列已满的连接
+func (h *Hub) evict(clients []*HubClient) {
+	for _, client := range clients {
+		client.close(ErrSlowConsumer)
+	}
+}
+
+func (h *Hub) emit(eventType string, room string, client *HubClient) {
+	if h.config.OnPresence != nil {
+		h.config.OnPresence(PresenceEvent{Type: eventType, Room: room, Client: client})
+	}
+}
+
+// 加入房间
+func (cl *HubClient) Join(room string) {
+	h := cl.hub
+	h.mu.Lock()
+	if _, ok := h.clients[cl]; !ok {
+		h.mu.Unlock()
+		return
+	}
+	if _, ok := cl.rooms[room]; ok {
+		h.mu.Unlock()
+		return
+	}
+	if h.rooms[room] == nil {
+		h.rooms[room] = make(map[*HubClient]struct{})
+	}
+	h.rooms[room][cl] = struct{}{}
+	cl.rooms[room] = struct{}{}
+	h.mu.Unlock()
+	h.emit(PRESENCE_JOIN, room, cl)
+}
+
+// 离开房间
+func (cl *HubClient) Leave(room string) {
+	h := cl.hub
+	h.mu.Lock()
+	if _, ok := cl.rooms[room]; !ok {
+		h.mu.Unlock()
+		return
+	}
+	cl.leaveLocked(room)
+	h.mu.Unlock()
+	h.emit(PRESENCE_LEAVE, room, cl)
+}
+
+func (cl *HubClient) leaveLocked(room string) {
+	h := cl.hub
+	delete(cl.rooms, room)
+	delete(h.rooms[room], cl)
+	if len(h.rooms[room]) == 0 {
+		delete(h.rooms, room)
+	}
+}
+
+// 单独给这个连接发送消息，队列已满时连接会被踢出
+func (cl *HubClient) Send(msg HubMessage) {
+	if !cl.enqueue(msg) {
+		cl.close(ErrSlowConsumer)
+	}
+}
+
+// 连接被关闭或被踢出时关闭
+func (cl *HubClient) Done() <-chan struct{} {
+	return cl.done
+}
+
+// 从 Hub 中移除连接并离开所有房间
+func (cl *HubClient) Close() {
+	cl.close(nil)
+}
+
+// 放入发送队列，队列已满时返回 false
+func (cl *HubClient) enqueue(msg HubMessage) bool {
+	select {
+	case <-cl.done:
+		return true
+	default:
+	}
+	select {
+	case cl.send <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+func (cl *HubClient) close(reason error) {
+	cl.closeOnce.Do(func() {
+		h := cl.hub
+		h.mu.Lock()
+		rooms := make([]string, 0, len(cl.rooms))
+		for room := range cl.rooms {
+			rooms = append(rooms, room)
+			cl.leaveLocked(room)
+		}
+		delete(h.clients, cl)
+		h.mu.Unlock()
+
+		close(cl.done)
+		if reason != nil {
+			cl.transport.Close(reason)
+		}
+		sort.Strings(rooms)
+		for _, room := range rooms {
+			h.emit(PRESENCE_LEAVE, room, cl)
+		}
+	})
+}
+
+func (cl *HubClient) writeLoop() {
+	for {
+		select {
+		case <-cl.done:
+			return
+		case msg := <-cl.send:
+			if err := cl.transport.Send(msg); err != nil {
+				cl.close(err)
+				return
+			}
+		}
+	}
+}
+
+type webSocketTransport struct {
+	conn *WebSocketConn
+}
+
+func (t *webSocketTransport) Send(msg HubMessage) error {
+	messageType := msg.Type
+	if messageType == 0 {
+		messageType = TEXT_MESSAGE
+	}
+	return t.conn.WriteMessage(messageType, msg.Data)
+}
+
+func (t *webSocketTransport) Close(reason error) error {
+	code := CLOSE_GOING_AWAY
+	if reason == ErrSlowConsumer {
+		code = CLOSE_POLICY_VIOLATION
+	}
+	return t.conn.Close(code, reason.Error())
+}
+
+type sseTransport struct {
+	sse *SSEWriter
+}
+
+func (t *sseTransport) Send(msg HubMessage) error {
+	return t.sse.Send(Event{Event: msg.Event, Data: string(msg.Data)})
+}
+
+// SSE 无法主动断开，由路由函数在 client.Done() 关闭后返回
+func (t *sseTransport) Close(reason error) error {
+	return nil
+}
@@ -0,0 +1,108 @@
+package capybara
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// 测试用的连接，block 不为空时发送会阻塞
+type testTransport struct {
+	mu       sync.Mutex
+	received []string
+	block    chan struct{}
+	closed   error
+}
+
+func (t *testTransport) Send(msg HubMessage) error {
+	if t.block != nil {
+		<-t.block
+	}
+	t.mu.Lock()
+	t.received = append(t.received, string(msg.Data))
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *testTransport) Close(reason error) error {
+	t.mu.Lock()
+	t.closed = reason
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *testTransport) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.received)
+}
+
+// 测试房间广播与在线状态事件
+func TestHubBroadcast(t *testing.T) {
+	var mu sync.Mutex
+	events := make([]string, 0)
+	hub := NewHub(HubConfig{OnPresence: func(e PresenceEvent) {
+		mu.Lock()
+		events = append(events, e.Type+":"+e.Room+":"+e.Client.ID)
+		mu.Unlock()
+	}})
+
+	a, b := &testTransport{}, &testTransport{}
+	ca := hub.Register("a", a)
+	cb := hub.Register("b", b)
+	ca.Join("chat")
+	cb.Join("chat")
+	cb.Join("news")
+	if members := hub.Members("chat"); len(members) != 2 || members[0] != "a" {
+		t.Errorf("房间成员异常: %v", members)
+	}
+
+	hub.Broadcast("chat", HubMessage{Data: []byte("hi")})
+	hub.Broadcast("news", HubMessage{Data: []byte("breaking")})
+	deadline := time.Now().Add(time.Second)
+	for (a.count() != 1 || b.count() != 2) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if a.count() != 1 || b.count() != 2 {
+		t.Errorf("广播消息数量异常: %d %d", a.count(), b.count())
+	}
+
+	cb.Close()
+	<-cb.Done()
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{"join:chat:a", "join:chat:b", "join:news:b", "leave:chat:b", "leave:news:b"}
+	if len(events) != len(expected) {
+		t.Fatalf("在线状态事件异常: %v", events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("在线状态事件异常: %v", events)
+		}
+	}
+}
+
+// 测试踢出慢连接
+func TestHubSlowConsumer(t *testing.T) {
+	hub := NewHub(HubConfig{SendQueueSize: 1})
+	slow := &testTransport{block: make(chan struct{})}
+	client := hub.Register("slow", slow)
+	client.Join("room")
+
+	// 第一条被发送 goroutine 取走并阻塞，第二条填满队列，第三条触发踢出
+	for i := 0; i < 3; i++ {
+		hub.Broadcast("room", HubMessage{Data: []byte("x")})
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Fatal("慢连接未被踢出")
+	}
+	close(slow.block)
+	slow.mu.Lock()
+	defer slow.mu.Unlock()
+	if slow.closed != ErrSlowConsumer || len(hub.Members("room")) != 0 {
+		t.Error("慢连接踢出后状态异常")
+	}
+}
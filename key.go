@@ -0,0 +1,45 @@
+package capybara
+
+import (
+	gocontext "context"
+)
+
+// 带类型的 Context 数据键
+//
+// 每次 NewKey 都会得到一个不同的键，不同包之间即使名字相同也不会冲突：
+//
+//	var UserKey = capybara.NewKey[*User]("user")
+//
+//	UserKey.With(ctx, user)
+//	user, ok := UserKey.Value(ctx)
+type Key[T any] struct {
+	name string
+}
+
+// 创建一个新的键，name 只用于调试输出
+func NewKey[T any](name string) *Key[T] {
+	return &Key[T]{name: name}
+}
+
+// 保存数据，同一个请求中的中间件与路由函数都可以读取
+func (k *Key[T]) With(ctx Context, value T) {
+	if c, ok := ctx.(*context); ok {
+		c.assertAlive()
+		if c.values == nil {
+			c.values = make(map[interface{}]interface{})
+		}
+		c.values[k] = value
+		return
+	}
+	ctx.SetContext(gocontext.WithValue(ctx.Request().Context(), k, value))
+}
+
+// 读取数据，没有保存过时返回零值和 false
+func (k *Key[T]) Value(ctx Context) (T, bool) {
+	value, ok := ctx.Value(k).(T)
+	return value, ok
+}
+
+func (k *Key[T]) String() string {
+	return "capybara.Key(" + k.name + ")"
+}
@@ -1,16 +1,181 @@
 package capybara
 
-import "log"
+import (
+	gocontext "context"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"runtime"
+	"time"
+)
 
-func InitLogger() *CapybaraLogger {
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
-	return &CapybaraLogger{serviceName: "server"}
+// 日志的输出格式
+const (
+	LOG_FORMAT_TEXT = "text"
+	LOG_FORMAT_JSON = "json"
+)
+
+var (
+	// 请求 ID，由 RequestID 中间件保存；不会直接读取客户端发送的 X-Request-Id 请求头
+	RequestIDKey = NewKey[string]("request_id")
+	// 当前用户的 ID，由认证中间件保存
+	UserIDKey = NewKey[string]("user_id")
+)
+
+// 分级的结构化日志，args 为交替出现的键值对，与 log/slog 相同
+//
+//	logger.Info("user created", "id", 7, "name", "capy")
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+	// 返回一个附带这些字段的 Logger
+	With(args ...interface{}) Logger
+}
+
+// 默认日志的配置
+type LoggerConfig struct {
+	// 日志级别，默认为 slog.LevelInfo；传入 *slog.LevelVar 可以在运行时调整
+	Level slog.Leveler
+	// 日志输出，默认为 os.Stderr
+	Output io.Writer
+	// LOG_FORMAT_TEXT 或 LOG_FORMAT_JSON，默认为文本
+	Format string
+	// 记录调用日志的源码位置
+	AddSource bool
+	// 自定义的 slog.Handler，设置后忽略以上配置
+	Handler slog.Handler
+}
+
+// 基于 log/slog 创建日志，不会修改 log 与 slog 的全局设置
+//
+//	c.Logger = capybara.NewLogger(capybara.LoggerConfig{Format: capybara.LOG_FORMAT_JSON, Level: slog.LevelDebug})
+func NewLogger(config LoggerConfig) Logger {
+	handler := config.Handler
+	if handler == nil {
+		output := config.Output
+		if output == nil {
+			output = os.Stderr
+		}
+		options := &slog.HandlerOptions{Level: config.Level, AddSource: config.AddSource}
+		if config.Format == LOG_FORMAT_JSON {
+			handler = slog.NewJSONHandler(output, options)
+		} else {
+			handler = slog.NewTextHandler(output, options)
+		}
+	}
+	return NewSlogLogger(slog.New(handler))
+}
+
+// 把已有的 *slog.Logger 包装成 Logger
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return &slogLogger{logger}
+}
+
+var discardLogger = NewLogger(LoggerConfig{Handler: slog.DiscardHandler})
+
+// 实例的日志，没有设置时丢弃所有日志
+func (c *capybara) logger() Logger {
+	if c == nil || c.Logger == nil {
+		return discardLogger
+	}
+	return c.Logger
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (l *slogLogger) Debug(msg string, args ...interface{}) {
+	l.log(slog.LevelDebug, msg, args)
+}
+
+func (l *slogLogger) Info(msg string, args ...interface{}) {
+	l.log(slog.LevelInfo, msg, args)
+}
+
+func (l *slogLogger) Warn(msg string, args ...interface{}) {
+	l.log(slog.LevelWarn, msg, args)
+}
+
+func (l *slogLogger) Error(msg string, args ...interface{}) {
+	l.log(slog.LevelError, msg, args)
+}
+
+func (l *slogLogger) With(args ...interface{}) Logger {
+	return &slogLogger{l.logger.With(args...)}
+}
+
+// 统一经过这里，AddSource 记录的是调用 Logger 的位置而不是这个文件
+func (l *slogLogger) log(level slog.Level, msg string, args []interface{}) {
+	ctx := gocontext.Background()
+	handler := l.logger.Handler()
+	if !handler.Enabled(ctx, level) {
+		return
+	}
+	var pcs [1]uintptr
+	// 跳过 runtime.Callers、log 以及 Debug/Info/Warn/Error
+	runtime.Callers(3, pcs[:])
+	record := slog.NewRecord(time.Now(), level, msg, pcs[0])
+	record.Add(args...)
+	handler.Handle(ctx, record)
 }
 
+// 请求级别的日志，带有请求 ID、请求方法、路由、真实 IP 以及用户 ID
+//
+// 请求 ID 与用户 ID 变化后会重新生成
+func (c *context) Logger() Logger {
+	c.assertAlive()
+	requestID, _ := RequestIDKey.Value(c)
+	userID, _ := UserIDKey.Value(c)
+	if c.logger != nil && c.logger.requestID == requestID && c.logger.userID == userID {
+		return c.logger.Logger
+	}
+
+	args := make([]interface{}, 0, 10)
+	if requestID != "" {
+		args = append(args, "request_id", requestID)
+	}
+	args = append(args, "method", c.r.Method)
+	if c.path != "" {
+		args = append(args, "route", c.path)
+	}
+	args = append(args, "ip", c.RealIP())
+	if userID != "" {
+		args = append(args, "user_id", userID)
+	}
+	c.logger = &requestLogger{c.capa.logger().With(args...), requestID, userID}
+	return c.logger.Logger
+}
+
+// 缓存的请求级别日志以及生成它时的 ID
+type requestLogger struct {
+	Logger
+	requestID string
+	userID    string
+}
+
+// 旧版的日志
+//
+// Deprecated: 使用 NewLogger 创建的 Logger，CapybaraLogger 只为兼容保留
 type CapybaraLogger struct {
 	serviceName string
+	logger      Logger
+}
+
+// 创建旧版的日志，输出到 log 包当前的输出
+//
+// Deprecated: 使用 NewLogger
+func InitLogger() *CapybaraLogger {
+	return &CapybaraLogger{serviceName: "server", logger: NewLogger(LoggerConfig{Output: log.Writer()})}
 }
 
 func (l *CapybaraLogger) Info(msg string) {
-	log.Printf("[%s] INFO - %s", l.serviceName, msg)
+	logger := l.logger
+	if logger == nil {
+		logger = NewLogger(LoggerConfig{Output: log.Writer()})
+	}
+	logger.Info(msg, "service", l.serviceName)
 }
@@ -0,0 +1,117 @@
+package capybara
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 测试日志级别与 JSON 格式
+func TestLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	level := new(slog.LevelVar)
+	logger := NewLogger(LoggerConfig{Output: buf, Format: LOG_FORMAT_JSON, Level: level, AddSource: true})
+
+	logger.Debug("hidden")
+	if buf.Len() != 0 {
+		t.Errorf("低于级别的日志被输出: %s", buf.String())
+	}
+	level.Set(slog.LevelDebug)
+	logger.With("service", "capybara").Debug("user created", "id", 7)
+
+	var entry struct {
+		Level   string `json:"level"`
+		Msg     string `json:"msg"`
+		Service string `json:"service"`
+		ID      int    `json:"id"`
+		Source  struct {
+			File string `json:"file"`
+		} `json:"source"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("JSON 日志解析失败: %v %s", err, buf.String())
+	}
+	if entry.Level != "DEBUG" || entry.Msg != "user created" || entry.Service != "capybara" || entry.ID != 7 {
+		t.Errorf("日志内容异常: %+v", entry)
+	}
+	if !strings.HasSuffix(entry.Source.File, "logger_test.go") {
+		t.Errorf("源码位置异常: %s", entry.Source.File)
+	}
+}
+
+// 测试错误处理使用实例的日志
+func TestLoggerErrorHandler(t *testing.T) {
+	buf := new(bytes.Buffer)
+	c := CreateCapybaraInstance()
+	c.Logger = NewLogger(LoggerConfig{Output: buf})
+	c.GET("/panic", func(ctx Context) { panic("boom") }, Recovery())
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/panic", nil))
+	if !strings.Contains(buf.String(), "level=ERROR") || !strings.Contains(buf.String(), "panic: boom") {
+		t.Errorf("错误没有写入日志: %s", buf.String())
+	}
+
+	c.Logger = nil
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/panic", nil))
+}
+
+// 测试请求级别的日志
+func TestContextLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	c := CreateCapybaraInstance()
+	c.Logger = NewLogger(LoggerConfig{Output: buf})
+	c.Use(RequestID(RequestIDConfig{}))
+	c.GET("/users/:id", func(ctx Context) {
+		ctx.Logger().Info("anonymous")
+		UserIDKey.With(ctx, "42")
+		ctx.Logger().Info("signed in")
+	})
+	r := httptest.NewRequest("GET", "/users/7", nil)
+	r.RemoteAddr = "203.0.113.7:1234"
+	r.Header.Set(HEADER_X_REQUEST_ID, "req-1")
+	c.ServeHTTP(httptest.NewRecorder(), r)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("日志行数异常: %q", lines)
+	}
+	fields := "request_id=req-1 method=GET route=/users/:id ip=203.0.113.7"
+	if !strings.HasSuffix(lines[0], "msg=anonymous "+fields) {
+		t.Errorf("请求字段异常: %s", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], "msg=\"signed in\" "+fields+" user_id=42") {
+		t.Errorf("用户 ID 没有更新: %s", lines[1])
+	}
+}
+
+// 测试没有 RequestID 中间件时不使用客户端发送的请求 ID
+func TestContextLoggerIgnoresHeader(t *testing.T) {
+	buf := new(bytes.Buffer)
+	c := CreateCapybaraInstance()
+	c.Logger = NewLogger(LoggerConfig{Output: buf})
+	c.GET("/", func(ctx Context) {
+		ctx.Logger().Info("hello")
+	})
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(HEADER_X_REQUEST_ID, "forged")
+	c.ServeHTTP(httptest.NewRecorder(), r)
+	if strings.Contains(buf.String(), "request_id") {
+		t.Errorf("使用了请求头中的请求 ID: %s", buf.String())
+	}
+}
+
+// 测试兼容旧版的 InitLogger
+func TestInitLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	output := log.Writer()
+	log.SetOutput(buf)
+	defer log.SetOutput(output)
+
+	InitLogger().Info("server started")
+	if !strings.Contains(buf.String(), `msg="server started" service=server`) {
+		t.Errorf("旧版日志输出异常: %s", buf.String())
+	}
+}
@@ -0,0 +1,388 @@
+package capybara
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Prometheus 文本格式
+const (
+	PROMETHEUS_TEXT = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// 默认的耗时分桶，单位为秒
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// 默认的响应大小分桶，单位为字节
+var SizeBuckets = []float64{100, 1000, 10000, 100000, 1e6, 1e7, 1e8}
+
+var (
+	metricNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNamePattern  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// 指标的注册中心，Handler 以 Prometheus 文本格式输出所有指标
+//
+//	orders := c.Metrics.NewCounter("orders_created_total", "Orders created.", "channel")
+//	orders.Inc("web")
+type Registry struct {
+	mu       sync.RWMutex
+	families map[string]*metricFamily
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*metricFamily)}
+}
+
+// 同名、同一组标签的指标
+type metricFamily struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+	mu      sync.RWMutex
+	series  map[string]*metricSeries
+}
+
+// 一组标签值对应的数据
+type metricSeries struct {
+	labelValues []string
+	// 计数器与仪表盘的值，math.Float64bits 编码
+	value atomic.Uint64
+	// 直方图的数据
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// 注册指标，名字或标签不合法、名字重复时 panic
+func (r *Registry) register(name string, help string, typ string, labels []string, buckets []float64) *metricFamily {
+	if !metricNamePattern.MatchString(name) {
+		panic("capybara: invalid metric name " + strconv.Quote(name))
+	}
+	for _, label := range labels {
+		if !labelNamePattern.MatchString(label) || strings.HasPrefix(label, "__") || typ == "histogram" && label == "le" {
+			panic("capybara: invalid label name " + strconv.Quote(label))
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.families[name]; exists {
+		panic("capybara: duplicate metric " + strconv.Quote(name))
+	}
+	family := &metricFamily{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*metricSeries),
+	}
+	r.families[name] = family
+	return family
+}
+
+// 取得标签值对应的数据，第一次使用时创建
+func (f *metricFamily) with(labelValues []string) *metricSeries {
+	if len(labelValues) != len(f.labels) {
+		panic("capybara: metric " + f.name + " expects " + strconv.Itoa(len(f.labels)) + " label values")
+	}
+	key := strings.Join(labelValues, "\xff")
+	f.mu.RLock()
+	s, ok := f.series[key]
+	f.mu.RUnlock()
+	if ok {
+		return s
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok = f.series[key]; !ok {
+		s = &metricSeries{labelValues: append([]string(nil), labelValues...)}
+		if f.typ == "histogram" {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (s *metricSeries) add(delta float64) {
+	for {
+		old := s.value.Load()
+		if s.value.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+// 只增不减的计数器
+type Counter struct {
+	family *metricFamily
+}
+
+// 注册计数器，名字按照惯例以 _total 结尾
+func (r *Registry) NewCounter(name string, help string, labels ...string) *Counter {
+	return &Counter{r.register(name, help, "counter", labels, nil)}
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.family.with(labelValues).add(1)
+}
+
+// 增加计数，负数会被忽略
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	c.family.with(labelValues).add(v)
+}
+
+// 可增可减的仪表盘
+type Gauge struct {
+	family *metricFamily
+}
+
+func (r *Registry) NewGauge(name string, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(name, help, "gauge", labels, nil)}
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.family.with(labelValues).value.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.family.with(labelValues).add(v)
+}
+
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+// 统计分布的直方图
+type Histogram struct {
+	family *metricFamily
+}
+
+// 注册直方图，buckets 为各个桶的上界，为空时使用 DefBuckets
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{r.register(name, help, "histogram", labels, buckets)}
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	s := h.family.with(labelValues)
+	i := sort.SearchFloat64s(h.family.buckets, v)
+	s.mu.Lock()
+	if i < len(s.counts) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+	s.mu.Unlock()
+}
+
+// 以 Prometheus 文本格式输出所有指标的路由函数
+//
+//	c.GET("/metrics", c.Metrics.Handler())
+func (r *Registry) Handler() HandlerFunc {
+	return func(ctx Context) {
+		buf := new(bytes.Buffer)
+		r.WriteText(buf)
+		ctx.Blob(http.StatusOK, PROMETHEUS_TEXT, buf.Bytes())
+	}
+}
+
+// 按名字排序输出所有指标
+func (r *Registry) WriteText(buf *bytes.Buffer) {
+	r.mu.RLock()
+	families := make([]*metricFamily, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.RUnlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+	for _, f := range families {
+		f.writeText(buf)
+	}
+}
+
+func (f *metricFamily) writeText(buf *bytes.Buffer) {
+	f.mu.RLock()
+	series := make([]*metricSeries, 0, len(f.series))
+	for _, s := range f.series {
+		series = append(series, s)
+	}
+	f.mu.RUnlock()
+	sort.Slice(series, func(i, j int) bool {
+		return strings.Join(series[i].labelValues, "\xff") < strings.Join(series[j].labelValues, "\xff")
+	})
+
+	if f.help != "" {
+		buf.WriteString("# HELP " + f.name + " " + escapeHelp(f.help) + "\n")
+	}
+	buf.WriteString("# TYPE " + f.name + " " + f.typ + "\n")
+	for _, s := range series {
+		if f.typ != "histogram" {
+			writeSample(buf, f.name, f.labels, s.labelValues, "", math.Float64frombits(s.value.Load()))
+			continue
+		}
+		s.mu.Lock()
+		counts, sum, count := append([]uint64(nil), s.counts...), s.sum, s.count
+		s.mu.Unlock()
+		cumulative := uint64(0)
+		for i, upper := range f.buckets {
+			cumulative += counts[i]
+			writeSample(buf, f.name+"_bucket", f.labels, s.labelValues, formatFloat(upper), float64(cumulative))
+		}
+		writeSample(buf, f.name+"_bucket", f.labels, s.labelValues, "+Inf", float64(count))
+		writeSample(buf, f.name+"_sum", f.labels, s.labelValues, "", sum)
+		writeSample(buf, f.name+"_count", f.labels, s.labelValues, "", float64(count))
+	}
+}
+
+// 写出一行数据，le 不为空时追加直方图的 le 标签
+func writeSample(buf *bytes.Buffer, name string, labels []string, values []string, le string, value float64) {
+	buf.WriteString(name)
+	if len(labels) != 0 || le != "" {
+		buf.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(label + `="` + escapeLabelValue(values[i]) + `"`)
+		}
+		if le != "" {
+			if len(labels) != 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(`le="` + le + `"`)
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(formatFloat(value))
+	buf.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// 请求指标中间件的配置
+type MetricsConfig struct {
+	// 注册指标的注册中心，通常为 c.Metrics
+	Registry *Registry
+	// 指标名的前缀，默认为 http
+	Namespace string
+	// 耗时的分桶，默认为 DefBuckets
+	Buckets []float64
+	// 响应大小的分桶，默认为 SizeBuckets
+	SizeBuckets []float64
+	// 不统计的路径，以 * 结尾时按前缀匹配，例如 /metrics
+	SkipPaths []string
+}
+
+// 记录请求数、处理中的请求数、耗时与响应大小，标签为请求方法、路由与状态码类别
+//
+// 使用路由而不是请求路径作为标签，找不到路由的请求记为 unmatched，避免标签数量无限增长
+//
+//	c.Use(capybara.RequestMetrics(capybara.MetricsConfig{Registry: c.Metrics, SkipPaths: []string{"/metrics"}}))
+//	c.GET("/metrics", c.Metrics.Handler())
+func RequestMetrics(config MetricsConfig) Middlewares {
+	if config.Registry == nil {
+		panic("capybara: MetricsConfig.Registry is required")
+	}
+	if config.Namespace == "" {
+		config.Namespace = "http"
+	}
+	if len(config.SizeBuckets) == 0 {
+		config.SizeBuckets = SizeBuckets
+	}
+	prefix := config.Namespace + "_"
+	requests := config.Registry.NewCounter(prefix+"requests_total", "Total number of HTTP requests.", "method", "route", "status")
+	inFlight := config.Registry.NewGauge(prefix+"requests_in_flight", "Number of HTTP requests being served.", "method", "route")
+	duration := config.Registry.NewHistogram(prefix+"request_duration_seconds", "HTTP request latency in seconds.", config.Buckets, "method", "route", "status")
+	size := config.Registry.NewHistogram(prefix+"response_size_bytes", "HTTP response size in bytes.", config.SizeBuckets, "method", "route", "status")
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx Context) {
+			r := ctx.Request()
+			if skipPath(config.SkipPaths, r.URL.Path) {
+				next(ctx)
+				return
+			}
+			method := metricMethod(r.Method)
+			route := ctx.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			inFlight.Inc(method, route)
+			start := time.Now()
+			defer func() {
+				inFlight.Dec(method, route)
+				status := strconv.Itoa(ctx.Response().Status/100) + "xx"
+				// 注册在 Recovery 里层时 panic 还没有被处理，响应状态仍是默认的 200
+				err := recover()
+				if err != nil {
+					status = "5xx"
+				}
+				requests.Inc(method, route, status)
+				duration.Observe(time.Since(start).Seconds(), method, route, status)
+				size.Observe(float64(ctx.Response().Size), method, route, status)
+				if err != nil {
+					panic(err)
+				}
+			}()
+			next(ctx)
+		}
+	}
+}
+
+// 非标准的请求方法统一记为 OTHER，避免标签数量无限增长
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
@@ -0,0 +1,114 @@
+package capybara
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 测试自定义指标的文本格式
+func TestRegistryText(t *testing.T) {
+	r := NewRegistry()
+	counter := r.NewCounter("orders_total", "Orders\ncreated.", "channel")
+	counter.Inc("web")
+	counter.Add(2, "app \"beta\"")
+	gauge := r.NewGauge("queue_depth", "")
+	gauge.Set(3)
+	gauge.Dec()
+	histogram := r.NewHistogram("job_seconds", "Job latency.", []float64{1, 0.5})
+	histogram.Observe(0.3)
+	histogram.Observe(0.7)
+	histogram.Observe(5)
+
+	buf := new(bytes.Buffer)
+	r.WriteText(buf)
+	expected := `# HELP job_seconds Job latency.
+# TYPE job_seconds histogram
+job_seconds_bucket{le="0.5"} 1
+job_seconds_bucket{le="1"} 2
+job_seconds_bucket{le="+Inf"} 3
+job_seconds_sum 6
+job_seconds_count 3
+# HELP orders_total Orders\ncreated.
+# TYPE orders_total counter
+orders_total{channel="app \"beta\""} 2
+orders_total{channel="web"} 1
+# TYPE queue_depth gauge
+queue_depth 2
+`
+	if buf.String() != expected {
+		t.Errorf("文本格式异常:\n%s", buf.String())
+	}
+
+	for _, register := range []func(){
+		func() { r.NewCounter("orders_total", "") },
+		func() { r.NewCounter("bad-name", "") },
+		func() { r.NewHistogram("h", "", nil, "le") },
+		func() { counter.Inc() },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("不合法的使用没有 panic")
+				}
+			}()
+			register()
+		}()
+	}
+}
+
+// 测试请求指标以路由而不是路径作为标签
+func TestRequestMetrics(t *testing.T) {
+	c := CreateCapybaraInstance()
+	c.Use(RequestMetrics(MetricsConfig{Registry: c.Metrics, SkipPaths: []string{"/metrics"}}))
+	c.GET("/users/:id", func(ctx Context) {
+		ctx.String(200, "capybara")
+	})
+	c.GET("/metrics", c.Metrics.Handler())
+	for _, target := range []string{"/users/1", "/users/2", "/missing", "/metrics"} {
+		c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Header().Get(CONTENT_TYPE) != PROMETHEUS_TEXT {
+		t.Errorf("Content-Type 异常: %s", w.Header().Get(CONTENT_TYPE))
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		`http_requests_total{method="GET",route="/users/:id",status="2xx"} 2`,
+		`http_requests_total{method="GET",route="unmatched",status="4xx"} 1`,
+		`http_requests_in_flight{method="GET",route="/users/:id"} 0`,
+		`http_request_duration_seconds_count{method="GET",route="/users/:id",status="2xx"} 2`,
+		`http_response_size_bytes_bucket{method="GET",route="/users/:id",status="2xx",le="100"} 2`,
+		`http_response_size_bytes_sum{method="GET",route="/users/:id",status="2xx"} 16`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("缺少指标: %s", line)
+		}
+	}
+	if strings.Contains(body, "/users/1") || strings.Contains(body, `route="/metrics"`) {
+		t.Errorf("标签中出现了请求路径:\n%s", body)
+	}
+}
+
+// 测试注册在 Recovery 里层时 panic 的请求记为 5xx
+func TestRequestMetricsPanic(t *testing.T) {
+	c := CreateCapybaraInstance()
+	c.Logger = NewLogger(LoggerConfig{Output: io.Discard})
+	c.Use(Recovery(), RequestMetrics(MetricsConfig{Registry: c.Metrics}))
+	c.GET("/panic", func(ctx Context) {
+		panic("boom")
+	})
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("GET", "/panic", nil))
+	if w.Code != 500 {
+		t.Errorf("panic 没有继续交给 Recovery 处理: %d", w.Code)
+	}
+	buf := new(bytes.Buffer)
+	c.Metrics.WriteText(buf)
+	if !strings.Contains(buf.String(), `http_requests_total{method="GET",route="/panic",status="5xx"} 1`) {
+		t.Errorf("panic 的请求没有记为 5xx:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,162 @@
+package capybara
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	ACCEPT = "Accept"
+)
+
+var (
+	ErrNotAcceptable = errors.New("not acceptable")
+	// 编码器无法处理这份数据时返回，内容协商会继续尝试下一个可接受的类型
+	ErrUnsupportedData = errors.New("unsupported data")
+)
+
+// 内容协商使用的编码器
+type Encoder func(c Context, code int, data interface{}) error
+
+// 可以通过渲染器渲染成 HTML 的数据
+type HTMLTemplate interface {
+	TemplateName() string
+}
+
+type negotiateEncoder struct {
+	mediaType string
+	encode    Encoder
+}
+
+// 默认的编码器，注册顺序即服务端的偏好顺序
+func defaultEncoders() []negotiateEncoder {
+	return []negotiateEncoder{
+		{APPLICATION_JSON, func(c Context, code int, data interface{}) error {
+			return c.JSON(code, data)
+		}},
+		// 先编码再写出响应头，无法编码成 XML 的数据（例如 map）交给下一个可接受的类型
+		{APPLICATION_XML, func(c Context, code int, data interface{}) error {
+			b, err := xml.Marshal(data)
+			if err != nil {
+				return ErrUnsupportedData
+			}
+			return c.XMLBlob(code, b)
+		}},
+		{TEXT_XML, func(c Context, code int, data interface{}) error {
+			b, err := xml.Marshal(data)
+			if err != nil {
+				return ErrUnsupportedData
+			}
+			return c.Blob(code, TEXT_XML, append([]byte(xml.Header), b...))
+		}},
+		{TEXT_HTML, encodeHTML},
+		{TEXT_PLAIN, func(c Context, code int, data interface{}) error {
+			return c.String(code, fmt.Sprint(data))
+		}},
+	}
+}
+
+// 字符串直接作为 HTML 发送，实现了 HTMLTemplate 的数据交给渲染器
+func encodeHTML(c Context, code int, data interface{}) error {
+	switch v := data.(type) {
+	case string:
+		return c.HTML(code, v)
+	case HTMLTemplate:
+		if capa := instanceOf(c); capa == nil || capa.Renderer == nil {
+			return ErrUnsupportedData
+		}
+		return c.Render(code, v.TemplateName(), data)
+	}
+	return ErrUnsupportedData
+}
+
+// 注册一个内容协商的编码器，已存在的类型会被替换
+//
+//	c.RegisterEncoder("application/yaml", func(c Context, code int, data interface{}) error {
+//		b, err := yaml.Marshal(data)
+//		...
+//	})
+func (c *capybara) RegisterEncoder(mediaType string, encoder Encoder) {
+	mediaType = strings.ToLower(mediaType)
+	for i := range c.encoders {
+		if c.encoders[i].mediaType == mediaType {
+			c.encoders[i].encode = encoder
+			return
+		}
+	}
+	c.encoders = append(c.encoders, negotiateEncoder{mediaType, encoder})
+}
+
+// 根据 Accept 请求头选择编码器发送数据
+//
+// 没有可接受的类型时不写响应，返回包装了 ErrNotAcceptable 的 406 HTTPError，交给 Context.Error 发送
+func (c *context) Negotiate(code int, data interface{}) error {
+	c.w.Header().Add(VARY, ACCEPT)
+	var encoders []negotiateEncoder
+	if c.capa != nil {
+		encoders = c.capa.encoders
+	}
+	for _, e := range negotiateOrder(c.r.Header.Get(ACCEPT), encoders) {
+		err := e.encode(c, code, data)
+		if err != ErrUnsupportedData {
+			return err
+		}
+	}
+	return NewHTTPError(http.StatusNotAcceptable).SetInternal(ErrNotAcceptable)
+}
+
+// 按照客户端的 q 值从高到低排列可接受的编码器，q 值相同时按服务端的注册顺序
+func negotiateOrder(accept string, encoders []negotiateEncoder) []negotiateEncoder {
+	if strings.TrimSpace(accept) == "" {
+		accept = "*/*"
+	}
+	specs := parseAccept(accept)
+
+	type candidate struct {
+		encoder negotiateEncoder
+		q       float64
+	}
+	candidates := make([]candidate, 0, len(encoders))
+	for _, e := range encoders {
+		if q := mediaTypeQuality(specs, e.mediaType); q > 0 {
+			candidates = append(candidates, candidate{e, q})
+		}
+	}
+	// 插入排序，保持相同 q 值时的注册顺序
+	for i := 1; i < len(candidates); i++ {
+		for j := i; j > 0 && candidates[j].q > candidates[j-1].q; j-- {
+			candidates[j], candidates[j-1] = candidates[j-1], candidates[j]
+		}
+	}
+	ordered := make([]negotiateEncoder, len(candidates))
+	for i := range candidates {
+		ordered[i] = candidates[i].encoder
+	}
+	return ordered
+}
+
+// 计算某个类型在 Accept 中的 q 值，越具体的匹配优先级越高
+//
+//	text/html > text/* > */*
+func mediaTypeQuality(specs []acceptSpec, mediaType string) float64 {
+	mainType, _, _ := strings.Cut(mediaType, "/")
+	q, specificity := 0.0, -1
+	for _, spec := range specs {
+		level := -1
+		switch {
+		case spec.value == mediaType:
+			level = 2
+		case spec.value == mainType+"/*":
+			level = 1
+		case spec.value == "*/*":
+			level = 0
+		}
+		if level > specificity {
+			q, specificity = spec.q, level
+		}
+	}
+	return q
+}
@@ -0,0 +1,77 @@
+package capybara
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+type negotiateData struct {
+	A string
+}
+
+func negotiate(c *capybara, accept string, data interface{}) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/", nil)
+	if accept != "" {
+		r.Header.Set(ACCEPT, accept)
+	}
+	ctx, w := newTestContext(c, r)
+	if err := ctx.Negotiate(200, data); err != nil {
+		ctx.Error(err)
+	}
+	return w
+}
+
+// 测试 q 值与通配符
+func TestNegotiate(t *testing.T) {
+	c := CreateCapybaraInstance()
+	testCases := []struct {
+		accept   string
+		expected string
+	}{
+		{"", APPLICATION_JSON},
+		{"application/xml;q=0.9, application/json;q=0.8", APPLICATION_XML},
+		{"text/*, application/json;q=0.5", TEXT_XML},
+		{"text/plain, */*;q=0.1", TEXT_PLAIN},
+		{"*/*, application/json;q=0", APPLICATION_XML},
+	}
+	for _, tc := range testCases {
+		w := negotiate(c, tc.accept, negotiateData{"b"})
+		if ct := w.Header().Get(CONTENT_TYPE); ct != tc.expected {
+			t.Errorf("内容协商错误: Accept %s 期望 %s 得到 %s", tc.accept, tc.expected, ct)
+		}
+		if w.Header().Get(VARY) != ACCEPT {
+			t.Error("未设置 Vary: Accept")
+		}
+	}
+}
+
+// 测试不可接受与自定义编码器
+func TestNegotiateNotAcceptable(t *testing.T) {
+	c := CreateCapybaraInstance()
+	// map 无法渲染成 HTML
+	if w := negotiate(c, "text/html", map[string]string{}); w.Code != 406 {
+		t.Errorf("不可接受的类型未返回 406: %d", w.Code)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(ACCEPT, "text/html")
+	ctx, w := newTestContext(c, r)
+	if err := ctx.Negotiate(200, 1); !errors.Is(err, ErrNotAcceptable) || w.Body.Len() != 0 {
+		t.Errorf("不可接受时应只返回错误而不写响应: %v %s", err, w.Body.String())
+	}
+
+	// map 无法编码成 XML，回退到 JSON 或返回 406
+	if w := negotiate(c, "application/xml, application/json;q=0.5", map[string]int{"a": 1}); w.Code != 200 || w.Header().Get(CONTENT_TYPE) != APPLICATION_JSON {
+		t.Errorf("XML 编码失败时没有回退: %d %s %s", w.Code, w.Header().Get(CONTENT_TYPE), w.Body.String())
+	}
+	if w := negotiate(c, "application/xml", map[string]int{"a": 1}); w.Code != 406 {
+		t.Errorf("XML 编码失败时没有返回 406: %d %s", w.Code, w.Body.String())
+	}
+
+	c.RegisterEncoder("text/csv", func(ctx Context, code int, data interface{}) error {
+		return ctx.Blob(code, "text/csv", []byte("a,b"))
+	})
+	if w := negotiate(c, "text/csv", nil); w.Body.String() != "a,b" {
+		t.Errorf("自定义编码器未生效: %s", w.Body.String())
+	}
+}
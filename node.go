@@ -1,16 +1,17 @@
 package capybara
 
 import (
+	"sort"
 	"strings"
 )
 
 type node struct {
-	name      string           // 当前结点的名字
-	childrens map[string]*node // 当前结点的子结点
-	isWild    bool             // 是否为通配符结点
-	handler   HandlerFunc      // 当前路由的路由函数
-	method    string           // 当前路由的请求方法
-	fullPath  string           // 当前结点的完整路由
+	name         string                 // 当前结点的名字
+	childrens    map[string]*node       // 当前结点的子结点
+	wildChildren []*node                // 通配符子结点，:参数 在前，*通配符 在后
+	isWild       bool                   // 是否为通配符结点
+	handlers     map[string]HandlerFunc // 每种请求方法对应的路由函数
+	fullPath     string                 // 当前结点的完整路由
 }
 
 // 插入路径
@@ -25,58 +26,111 @@ func (n *node) insertRoute(path string, method string, handler HandlerFunc) {
 	currNode := n
 	for i := 0; i < len(segments); i++ {
 		if _, exists := currNode.childrens[segments[i]]; !exists {
-			currNode.childrens[segments[i]] = &node{
-				name:      segments[i],
-				childrens: make(map[string]*node),
-				isWild:    strings.HasPrefix(segments[i], ":") || segments[i][0] == '*',
+			child := InitNode()
+			child.name = segments[i]
+			child.isWild = strings.HasPrefix(segments[i], ":") || segments[i][0] == '*'
+			currNode.childrens[segments[i]] = child
+			if child.isWild {
+				currNode.addWildChild(child)
 			}
-
 		}
 		currNode = currNode.childrens[segments[i]]
 	}
-	currNode.handler = handler
-	currNode.method = method
+	currNode.handlers[method] = handler
 	currNode.fullPath = path
 }
 
+// 按照 :参数 在前、*通配符 在后，同类按名字排序，保证匹配顺序是确定的
+func (n *node) addWildChild(child *node) {
+	n.wildChildren = append(n.wildChildren, child)
+	sort.SliceStable(n.wildChildren, func(i, j int) bool {
+		a, b := n.wildChildren[i].name, n.wildChildren[j].name
+		if a[0] != b[0] {
+			return a[0] == ':'
+		}
+		return a < b
+	})
+}
+
 // 找结点路径
+//
+// 静态结点优先，其次是 :参数 结点，最后是 *通配符 结点，某条分支匹配不到时会回溯，
+// 所以 /users/:id 与 /users/:userId/posts 可以同时存在
 func (n *node) FindRoute(path string) (*node, map[string]string) {
 	if path == "" {
 		return nil, nil
 	}
 	segments := splitPath(path)
-	currNode := n
 	params := make(map[string]string)
-	for i, seg := range segments {
-		if child, exists := currNode.childrens[seg]; exists {
-			currNode = child
-			continue
+	currNode := n.match(segments, params)
+	if currNode == nil {
+		return nil, nil
+	}
+	return currNode, params
+}
+
+func (n *node) match(segments []string, params map[string]string) *node {
+	if len(segments) == 0 {
+		if len(n.handlers) == 0 {
+			return nil
 		}
-		for key, child := range currNode.childrens {
-			if child.isWild {
-				if strings.HasPrefix(key, ":") {
-					params[key[1:]] = seg
-				} else if strings.HasPrefix(key, "*") {
-					// 遇到了通配符，捕获剩余的路径
-					longPath := strings.Join(segments[i:], "/")
-					params[key[1:]] = longPath
-				}
-				currNode = child
+		return n
+	}
+	seg := segments[0]
+	if child, exists := n.childrens[seg]; exists && !child.isWild {
+		if found := child.match(segments[1:], params); found != nil {
+			return found
+		}
+	}
+	for _, child := range n.wildChildren {
+		if child.name[0] == ':' {
+			if found := child.match(segments[1:], params); found != nil {
+				params[child.name[1:]] = seg
+				return found
 			}
+			continue
+		}
+		// 遇到了通配符，捕获剩余的路径
+		if len(child.handlers) != 0 {
+			params[child.name[1:]] = strings.Join(segments, "/")
+			return child
 		}
 	}
-	if currNode.handler == nil {
-		return nil, nil
+	return nil
+}
+
+// 找到路径对应的路由函数，没有单独注册 HEAD 时使用 GET 的路由函数
+func (n *node) handler(method string) HandlerFunc {
+	if h, ok := n.handlers[method]; ok {
+		return h
 	}
-	return currNode, params
+	if method == "HEAD" {
+		return n.handlers["GET"]
+	}
+	return nil
+}
+
+// 结点上注册的请求方法，注册了 GET 时包含 HEAD
+func (n *node) allowedMethods() []string {
+	methods := make([]string, 0, len(n.handlers)+1)
+	for method := range n.handlers {
+		methods = append(methods, method)
+	}
+	if _, ok := n.handlers["GET"]; ok {
+		if _, ok := n.handlers["HEAD"]; !ok {
+			methods = append(methods, "HEAD")
+		}
+	}
+	sort.Strings(methods)
+	return methods
 }
 
 // 初始化单个结点
 func InitNode() *node {
 	return &node{
 		name:      "",
-		method:    "",
 		childrens: make(map[string]*node),
+		handlers:  make(map[string]HandlerFunc),
 		isWild:    false,
 	}
 }
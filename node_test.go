@@ -48,9 +48,10 @@ func TestMethodOverride(t *testing.T) {
 	root.insertRoute("/login", "GET", getHandler)
 	root.insertRoute("/login", "POST", postHandler)
 
-	// 验证方法存储
-	if n, _ := root.FindRoute("/login"); n.method != "POST" {
-		t.Error("方法覆盖异常")
+	// 验证方法存储：同一路径的不同方法互不覆盖
+	n, _ := root.FindRoute("/login")
+	if n.handlers["GET"] == nil || n.handlers["POST"] == nil {
+		t.Error("方法存储异常")
 	}
 }
 
@@ -116,7 +117,7 @@ func TestNodeInitialization(t *testing.T) {
 	if n.childrens == nil {
 		t.Error("子节点映射初始化失败")
 	}
-	if n.method != "" {
+	if n.handlers == nil || len(n.handlers) != 0 {
 		t.Error("节点方法初始化异常")
 	}
 }
@@ -148,3 +149,20 @@ func TestParamOverride(t *testing.T) {
 		t.Error("多参数解析失败")
 	}
 }
+
+// 测试同一位置不同参数名时的回溯匹配
+func TestParamBacktracking(t *testing.T) {
+	root := InitNode()
+	testHandler := func(c Context) {}
+
+	root.insertRoute("/users/:id", "GET", testHandler)
+	root.insertRoute("/users/:userId/posts", "GET", testHandler)
+
+	n, params := root.FindRoute("/users/7/posts")
+	if n == nil || n.fullPath != "/users/:userId/posts" || params["userId"] != "7" || params["id"] != "" {
+		t.Errorf("回溯匹配失败: %v", params)
+	}
+	if n, _ := root.FindRoute("/users/7/comments"); n != nil {
+		t.Error("不存在的子路径被匹配")
+	}
+}
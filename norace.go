@@ -0,0 +1,5 @@
+//go:build !race
+
+package capybara
+
+const raceEnabled = false
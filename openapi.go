@@ -0,0 +1,461 @@
+package capybara
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const OPENAPI_VERSION = "3.1.0"
+
+// 内置的离线文档页面，从同源的 spec 地址读取文档
+//
+//go:embed openapi_docs.html
+var openAPIDocsHTML string
+
+// OpenAPI 文档
+type OpenAPI struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       OpenAPIInfo                      `json:"info"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components *Components                      `json:"components,omitempty"`
+}
+
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas,omitempty"`
+}
+
+type Operation struct {
+	OperationID string                      `json:"operationId,omitempty"`
+	Summary     string                      `json:"summary,omitempty"`
+	Description string                      `json:"description,omitempty"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Parameters  []*Parameter                `json:"parameters,omitempty"`
+	RequestBody *RequestBody                `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+	Deprecated  bool                        `json:"deprecated,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema,omitempty"`
+}
+
+type RequestBody struct {
+	Description string                `json:"description,omitempty"`
+	Required    bool                  `json:"required,omitempty"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+// 文档中的响应描述
+type OpenAPIResponse struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+// JSON Schema 的 type，3.1 中可以是单个类型也可以是类型数组
+type SchemaType []string
+
+func (t SchemaType) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+func (t *SchemaType) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*t = SchemaType{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(b, &multiple); err != nil {
+		return err
+	}
+	*t = multiple
+	return nil
+}
+
+// 包含某个类型
+func (t SchemaType) Has(name string) bool {
+	for _, v := range t {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// JSON Schema
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 SchemaType         `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	// additionalProperties: false
+	NoAdditionalProperties bool          `json:"-"`
+	Enum                   []interface{} `json:"enum,omitempty"`
+	Minimum                *float64      `json:"minimum,omitempty"`
+	Maximum                *float64      `json:"maximum,omitempty"`
+	MinLength              *int          `json:"minLength,omitempty"`
+	MaxLength              *int          `json:"maxLength,omitempty"`
+	Pattern                string        `json:"pattern,omitempty"`
+	MinItems               *int          `json:"minItems,omitempty"`
+	MaxItems               *int          `json:"maxItems,omitempty"`
+	Nullable               bool          `json:"nullable,omitempty"`
+	AllOf                  []*Schema     `json:"allOf,omitempty"`
+	AnyOf                  []*Schema     `json:"anyOf,omitempty"`
+	OneOf                  []*Schema     `json:"oneOf,omitempty"`
+}
+
+// additionalProperties 可以是布尔值也可以是 Schema
+func (s *Schema) UnmarshalJSON(b []byte) error {
+	type plain Schema
+	aux := struct {
+		*plain
+		AdditionalProperties json.RawMessage `json:"additionalProperties,omitempty"`
+	}{plain: (*plain)(s)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	switch strings.TrimSpace(string(aux.AdditionalProperties)) {
+	case "", "true":
+	case "false":
+		s.NoAdditionalProperties = true
+	default:
+		s.AdditionalProperties = new(Schema)
+		return json.Unmarshal(aux.AdditionalProperties, s.AdditionalProperties)
+	}
+	return nil
+}
+
+func (s Schema) MarshalJSON() ([]byte, error) {
+	type plain Schema
+	if !s.NoAdditionalProperties {
+		return json.Marshal(plain(s))
+	}
+	return json.Marshal(struct {
+		plain
+		AdditionalProperties bool `json:"additionalProperties"`
+	}{plain: plain(s)})
+}
+
+// 路由的文档信息
+type RouteDoc struct {
+	OperationID string
+	Summary     string
+	Description string
+	Tags        []string
+	Deprecated  bool
+	// 请求类型的零值，param / query 标签的字段生成参数，其余字段生成 JSON 请求体
+	Request interface{}
+	// 200 响应体类型的零值
+	Response interface{}
+	// 其他状态码的响应体类型，值可以为 nil
+	Responses map[int]interface{}
+}
+
+// 为已注册的路由添加文档信息
+//
+//	c.POST("/users", createUser)
+//	c.Doc("POST", "/users", capybara.RouteDoc{Summary: "创建用户", Tags: []string{"user"}, Request: CreateUser{}, Response: User{}})
+func (c *capybara) Doc(method string, path string, doc RouteDoc) {
+	if c.routeDocs == nil {
+		c.routeDocs = make(map[string]RouteDoc)
+	}
+	c.routeDocs[method+" "+path] = doc
+}
+
+// 为路由组中的路由添加文档信息
+func (r *Router) Doc(method string, path string, doc RouteDoc) {
+	r.c.Doc(method, joinPath(r.prefix, path), doc)
+}
+
+// 在 specPath 提供 OpenAPI 文档，docsPath 不为空时在该地址提供离线的文档页面
+//
+//	c.ServeOpenAPI("/openapi.json", "/docs", capybara.OpenAPIInfo{Title: "API", Version: "1.0.0"})
+func (c *capybara) ServeOpenAPI(specPath string, docsPath string, info OpenAPIInfo) {
+	if c.hiddenRoutes == nil {
+		c.hiddenRoutes = make(map[string]bool)
+	}
+	c.hiddenRoutes[specPath] = true
+	c.GET(specPath, func(ctx Context) {
+		ctx.JSON(http.StatusOK, c.OpenAPI(info))
+	})
+	if docsPath != "" {
+		c.hiddenRoutes[docsPath] = true
+		specURL, _ := json.Marshal(specPath)
+		page := strings.Replace(openAPIDocsHTML, `"{{SPEC_URL}}"`, string(specURL), 1)
+		c.GET(docsPath, func(ctx Context) {
+			ctx.HTML(http.StatusOK, page)
+		})
+	}
+}
+
+// 根据路由树生成 OpenAPI 文档
+func (c *capybara) OpenAPI(info OpenAPIInfo) *OpenAPI {
+	g := &schemaGenerator{schemas: make(map[string]*Schema), seen: make(map[reflect.Type]string)}
+	spec := &OpenAPI{
+		OpenAPI: OPENAPI_VERSION,
+		Info:    info,
+		Paths:   make(map[string]map[string]*Operation),
+	}
+	c.router.tree.walk(func(n *node) {
+		if c.hiddenRoutes[n.fullPath] {
+			return
+		}
+		path, params := openAPIPath(n.fullPath)
+		for method := range n.handlers {
+			op := c.operation(g, method, n.fullPath, params)
+			if spec.Paths[path] == nil {
+				spec.Paths[path] = make(map[string]*Operation)
+			}
+			spec.Paths[path][strings.ToLower(method)] = op
+		}
+	})
+	if len(g.schemas) != 0 {
+		spec.Components = &Components{Schemas: g.schemas}
+	}
+	return spec
+}
+
+// 深度优先遍历所有注册了路由函数的结点
+func (n *node) walk(fn func(n *node)) {
+	if len(n.handlers) != 0 {
+		fn(n)
+	}
+	keys := make([]string, 0, len(n.childrens))
+	for key := range n.childrens {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		n.childrens[key].walk(fn)
+	}
+}
+
+// 将 :param 和 *wildcard 转换为 {param}
+//
+//	/users/:id/files/*path => /users/{id}/files/{path}
+func openAPIPath(path string) (string, []string) {
+	segments := splitPath(path)
+	params := make([]string, 0)
+	for i, seg := range segments {
+		if seg[0] == ':' || seg[0] == '*' {
+			params = append(params, seg[1:])
+			segments[i] = "{" + seg[1:] + "}"
+		}
+	}
+	return "/" + strings.Join(segments, "/"), params
+}
+
+func (c *capybara) operation(g *schemaGenerator, method string, fullPath string, pathParams []string) *Operation {
+	doc := c.routeDocs[method+" "+fullPath]
+	op := &Operation{
+		OperationID: doc.OperationID,
+		Summary:     doc.Summary,
+		Description: doc.Description,
+		Tags:        doc.Tags,
+		Deprecated:  doc.Deprecated,
+		Responses:   make(map[string]*OpenAPIResponse),
+	}
+
+	// 路由参数默认是字符串，请求类型中有对应的 param 字段时使用字段的类型
+	fieldSchemas := make(map[string]*Schema)
+	queryParams := make([]*Parameter, 0)
+	var body *Schema
+	if doc.Request != nil {
+		t := derefType(reflect.TypeOf(doc.Request))
+		if t.Kind() == reflect.Struct {
+			props := make(map[string]*Schema)
+			required := make([]string, 0)
+			forEachField(t, func(f reflect.StructField) {
+				if name := tagName(f, "param"); name != "" {
+					fieldSchemas[name] = g.schema(f.Type)
+					return
+				}
+				if name := tagName(f, "query"); name != "" {
+					queryParams = append(queryParams, &Parameter{Name: name, In: "query", Schema: g.schema(f.Type)})
+					return
+				}
+				name, omitempty := jsonName(f)
+				if name == "" {
+					return
+				}
+				props[name] = g.schema(f.Type)
+				if !omitempty && f.Type.Kind() != reflect.Pointer {
+					required = append(required, name)
+				}
+			})
+			if len(props) != 0 {
+				body = &Schema{Type: SchemaType{"object"}, Properties: props, Required: required}
+			}
+		} else {
+			body = g.schema(t)
+		}
+	}
+
+	for _, name := range pathParams {
+		schema := fieldSchemas[name]
+		if schema == nil {
+			schema = &Schema{Type: SchemaType{"string"}}
+		}
+		op.Parameters = append(op.Parameters, &Parameter{Name: name, In: "path", Required: true, Schema: schema})
+	}
+	op.Parameters = append(op.Parameters, queryParams...)
+	if body != nil && method != http.MethodGet && method != http.MethodHead && method != http.MethodDelete {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  map[string]*MediaType{APPLICATION_JSON: {Schema: body}},
+		}
+	}
+
+	op.Responses["200"] = g.response(http.StatusOK, doc.Response)
+	for code, v := range doc.Responses {
+		op.Responses[strconv.Itoa(code)] = g.response(code, v)
+	}
+	return op
+}
+
+// 将 Go 类型转换为 JSON Schema，具名结构体放入 components 中复用
+type schemaGenerator struct {
+	schemas map[string]*Schema
+	seen    map[reflect.Type]string
+}
+
+func (g *schemaGenerator) response(code int, v interface{}) *OpenAPIResponse {
+	resp := &OpenAPIResponse{Description: http.StatusText(code)}
+	if v != nil {
+		resp.Content = map[string]*MediaType{APPLICATION_JSON: {Schema: g.schema(reflect.TypeOf(v))}}
+	}
+	return resp
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g *schemaGenerator) schema(t reflect.Type) *Schema {
+	t = derefType(t)
+	if t == timeType {
+		return &Schema{Type: SchemaType{"string"}, Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: SchemaType{"string"}}
+	case reflect.Bool:
+		return &Schema{Type: SchemaType{"boolean"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if t.Kind() == reflect.Int64 || t.Kind() == reflect.Uint64 {
+			return &Schema{Type: SchemaType{"integer"}, Format: "int64"}
+		}
+		return &Schema{Type: SchemaType{"integer"}}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: SchemaType{"number"}}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: SchemaType{"string"}, Format: "byte"}
+		}
+		return &Schema{Type: SchemaType{"array"}, Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: SchemaType{"object"}, AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		return g.structSchema(t)
+	}
+	return &Schema{}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) *Schema {
+	name := t.Name()
+	if name != "" {
+		if ref, ok := g.seen[t]; ok {
+			return &Schema{Ref: "#/components/schemas/" + ref}
+		}
+		// 不同包中的同名类型加上包名区分
+		if _, exists := g.schemas[name]; exists {
+			name = strings.ReplaceAll(t.PkgPath(), "/", ".") + "." + name
+		}
+		g.seen[t] = name
+	}
+	schema := &Schema{Type: SchemaType{"object"}, Properties: make(map[string]*Schema)}
+	forEachField(t, func(f reflect.StructField) {
+		jsonKey, omitempty := jsonName(f)
+		if jsonKey == "" {
+			return
+		}
+		schema.Properties[jsonKey] = g.schema(f.Type)
+		if !omitempty && f.Type.Kind() != reflect.Pointer {
+			schema.Required = append(schema.Required, jsonKey)
+		}
+	})
+	if name == "" {
+		return schema
+	}
+	g.schemas[name] = schema
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// 遍历结构体的导出字段，展开匿名嵌入的结构体
+func forEachField(t reflect.Type, fn func(f reflect.StructField)) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		if f.Anonymous && derefType(f.Type).Kind() == reflect.Struct && f.Tag.Get("json") == "" {
+			forEachField(derefType(f.Type), fn)
+			continue
+		}
+		fn(f)
+	}
+}
+
+func derefType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+func tagName(f reflect.StructField, tag string) string {
+	name, _, _ := strings.Cut(f.Tag.Get(tag), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// 字段在 JSON 中的名字，以及是否带有 omitempty
+func jsonName(f reflect.StructField) (string, bool) {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	name, opts, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = f.Name
+	}
+	return name, strings.Contains(opts, "omitempty")
+}
@@ -0,0 +1,78 @@
+package capybara
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type docUser struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email,omitempty"`
+	Friends   []docUser `json:"friends,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type docUpdateUser struct {
+	ID     int    `param:"id"`
+	Notify bool   `query:"notify"`
+	Name   string `json:"name"`
+}
+
+// 测试根据路由树生成文档
+func TestOpenAPI(t *testing.T) {
+	c := CreateCapybaraInstance()
+	handler := func(ctx Context) {}
+	c.GET("/users/:id", handler)
+	c.PUT("/users/:id", handler)
+	c.GET("/files/*filepath", handler)
+	c.Doc("PUT", "/users/:id", RouteDoc{Summary: "更新用户", Tags: []string{"user"}, Request: docUpdateUser{}, Response: docUser{}})
+	c.ServeOpenAPI("/openapi.json", "/docs", OpenAPIInfo{Title: "test", Version: "1.0.0"})
+
+	spec := c.OpenAPI(OpenAPIInfo{Title: "test", Version: "1.0.0"})
+	if _, ok := spec.Paths["/openapi.json"]; ok {
+		t.Error("文档自身的路由出现在文档中")
+	}
+	if op := spec.Paths["/files/{filepath}"]["get"]; op == nil || op.Parameters[0].Name != "filepath" {
+		t.Error("通配符路由转换失败")
+	}
+
+	put := spec.Paths["/users/{id}"]["put"]
+	if put == nil || put.Summary != "更新用户" {
+		t.Fatal("路由文档信息丢失")
+	}
+	if p := put.Parameters[0]; p.In != "path" || !p.Schema.Type.Has("integer") {
+		t.Error("路由参数的类型未从请求类型中读取")
+	}
+	if p := put.Parameters[1]; p.In != "query" || p.Name != "notify" {
+		t.Error("查询参数生成失败")
+	}
+	if body := put.RequestBody.Content[APPLICATION_JSON].Schema; len(body.Properties) != 1 || body.Properties["name"] == nil {
+		t.Error("请求体只应包含 JSON 字段")
+	}
+	if ref := put.Responses["200"].Content[APPLICATION_JSON].Schema.Ref; ref != "#/components/schemas/docUser" {
+		t.Errorf("响应类型未引用 components: %s", ref)
+	}
+	user := spec.Components.Schemas["docUser"]
+	if user.Properties["friends"].Items.Ref != "#/components/schemas/docUser" || user.Properties["createdAt"].Format != "date-time" {
+		t.Error("递归类型或时间类型生成失败")
+	}
+	if strings.Join(user.Required, ",") != "id,name,createdAt" {
+		t.Errorf("必填字段错误: %v", user.Required)
+	}
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	var served map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &served); err != nil || served["openapi"] != OPENAPI_VERSION {
+		t.Error("文档接口输出异常")
+	}
+	w = httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("GET", "/docs", nil))
+	if !strings.Contains(w.Body.String(), `var specURL = "/openapi.json";`) {
+		t.Error("文档页面未指向文档接口")
+	}
+}
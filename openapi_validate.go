@@ -0,0 +1,644 @@
+package capybara
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 读取 JSON 格式的 OpenAPI 3 文档
+//
+// 路径级别的 parameters 会合并到每个操作中，$ref 只支持 #/components/schemas 下的 Schema
+func LoadOpenAPI(r io.Reader) (*OpenAPI, error) {
+	var raw struct {
+		OpenAPI    string                                `json:"openapi"`
+		Info       OpenAPIInfo                           `json:"info"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components *Components                           `json:"components"`
+	}
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(raw.OpenAPI, "3.") {
+		return nil, fmt.Errorf("openapi: unsupported version %q", raw.OpenAPI)
+	}
+	spec := &OpenAPI{
+		OpenAPI:    raw.OpenAPI,
+		Info:       raw.Info,
+		Paths:      make(map[string]map[string]*Operation),
+		Components: raw.Components,
+	}
+	for path, item := range raw.Paths {
+		var shared []*Parameter
+		if b, ok := item["parameters"]; ok {
+			if err := json.Unmarshal(b, &shared); err != nil {
+				return nil, fmt.Errorf("openapi: %s parameters: %w", path, err)
+			}
+		}
+		spec.Paths[path] = make(map[string]*Operation)
+		for method, b := range item {
+			if !isHTTPMethod(method) {
+				continue
+			}
+			op := new(Operation)
+			if err := json.Unmarshal(b, op); err != nil {
+				return nil, fmt.Errorf("openapi: %s %s: %w", method, path, err)
+			}
+			op.Parameters = mergeParameters(shared, op.Parameters)
+			spec.Paths[path][method] = op
+		}
+	}
+	return spec, nil
+}
+
+// 从文件读取 OpenAPI 文档
+func LoadOpenAPIFile(name string) (*OpenAPI, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadOpenAPI(f)
+}
+
+func isHTTPMethod(method string) bool {
+	switch method {
+	case "get", "put", "post", "delete", "options", "head", "patch", "trace":
+		return true
+	}
+	return false
+}
+
+// 操作级别的参数覆盖同名同位置的路径级别参数
+func mergeParameters(shared []*Parameter, own []*Parameter) []*Parameter {
+	merged := make([]*Parameter, 0, len(shared)+len(own))
+	for _, p := range shared {
+		overridden := false
+		for _, o := range own {
+			if o.Name == p.Name && o.In == p.In {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			merged = append(merged, p)
+		}
+	}
+	return append(merged, own...)
+}
+
+// OpenAPI 校验中间件的配置
+type OpenAPIValidatorConfig struct {
+	Spec *OpenAPI
+	// 文档中的路径相对的前缀，例如 servers 中的 /api/v1
+	BasePath string
+	// 文档中没有的请求返回 404，默认放行
+	RejectUnknown bool
+	// 校验响应体，只建议在测试中开启；不符合文档时改为 500 响应
+	ValidateResponses bool
+	// 校验时读取的请求体上限，超过时返回 413，默认为 10MB
+	MaxBodySize int64
+}
+
+// 违反 OpenAPI 文档的错误
+type ValidationError struct {
+	// 出错的位置，例如 query.page、body.items[0].name
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+type specRoute struct {
+	method   string
+	segments []string
+	literals int
+	op       *Operation
+}
+
+// 根据 OpenAPI 文档校验请求，违反文档的请求以 400 交给集中的错误处理函数
+//
+//	spec, _ := capybara.LoadOpenAPIFile("openapi.json")
+//	c.Use(capybara.OpenAPIValidation(capybara.OpenAPIValidatorConfig{Spec: spec}))
+func OpenAPIValidation(config OpenAPIValidatorConfig) Middlewares {
+	routes := make([]specRoute, 0)
+	for path, item := range config.Spec.Paths {
+		segments := splitPath(path)
+		literals := 0
+		for _, seg := range segments {
+			if !strings.HasPrefix(seg, "{") {
+				literals++
+			}
+		}
+		for method, op := range item {
+			routes = append(routes, specRoute{strings.ToUpper(method), segments, literals, op})
+		}
+	}
+	// 字面量越多的路径越具体，优先匹配
+	sort.SliceStable(routes, func(i, j int) bool { return routes[i].literals > routes[j].literals })
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = defaultMaxBodySize
+	}
+	v := &specValidator{spec: config.Spec, maxBodySize: config.MaxBodySize}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) {
+			r := c.Request()
+			path := r.URL.Path
+			if config.BasePath != "" {
+				if !strings.HasPrefix(path, config.BasePath) {
+					next(c)
+					return
+				}
+				path = strings.TrimPrefix(path, config.BasePath)
+			}
+			route, pathParams := matchSpecRoute(routes, r.Method, splitPath(path))
+			if route == nil {
+				if config.RejectUnknown {
+					c.Error(NewHTTPError(http.StatusNotFound))
+					return
+				}
+				next(c)
+				return
+			}
+			if err := v.validateRequest(c.Response(), r, route.op, pathParams); err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					c.Error(NewHTTPError(http.StatusRequestEntityTooLarge).SetInternal(err))
+					return
+				}
+				c.Error(NewHTTPError(http.StatusBadRequest, err.Error()).SetInternal(err))
+				return
+			}
+			if !config.ValidateResponses {
+				next(c)
+				return
+			}
+			v.validateResponse(c, route.op, next)
+		}
+	}
+}
+
+func matchSpecRoute(routes []specRoute, method string, segments []string) (*specRoute, map[string]string) {
+	for i := range routes {
+		route := &routes[i]
+		if route.method != method && !(method == http.MethodHead && route.method == http.MethodGet) {
+			continue
+		}
+		if len(route.segments) != len(segments) {
+			continue
+		}
+		params := make(map[string]string)
+		matched := true
+		for j, seg := range route.segments {
+			if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+				params[seg[1:len(seg)-1]] = segments[j]
+				continue
+			}
+			if seg != segments[j] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return route, params
+		}
+	}
+	return nil, nil
+}
+
+// 默认的请求体上限
+const defaultMaxBodySize = 10 << 20
+
+type specValidator struct {
+	spec        *OpenAPI
+	maxBodySize int64
+	patterns    sync.Map
+}
+
+func (v *specValidator) validateRequest(w http.ResponseWriter, r *http.Request, op *Operation, pathParams map[string]string) error {
+	query := r.URL.Query()
+	for _, p := range op.Parameters {
+		var values []string
+		switch p.In {
+		case "path":
+			if value, ok := pathParams[p.Name]; ok {
+				values = []string{value}
+			}
+		case "query":
+			values = query[p.Name]
+		case "header":
+			values = r.Header.Values(p.Name)
+		default:
+			continue
+		}
+		field := p.In + "." + p.Name
+		if len(values) == 0 {
+			if p.Required || p.In == "path" {
+				return &ValidationError{field, "is required"}
+			}
+			continue
+		}
+		if p.Schema == nil {
+			continue
+		}
+		value, err := v.parseParameter(p.Schema, values)
+		if err != nil {
+			return &ValidationError{field, err.Error()}
+		}
+		if err := v.validate(p.Schema, value, field); err != nil {
+			return err
+		}
+	}
+
+	if op.RequestBody == nil {
+		return nil
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, v.maxBodySize))
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	// 放回请求体，路由函数还需要读取
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if len(body) == 0 {
+		if op.RequestBody.Required {
+			return &ValidationError{"body", "is required"}
+		}
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get(CONTENT_TYPE))
+	content, ok := op.RequestBody.Content[mediaType]
+	if !ok {
+		return &ValidationError{"body", "unsupported content type " + strconv.Quote(mediaType)}
+	}
+	if content.Schema == nil || !isJSONMediaType(mediaType) {
+		return nil
+	}
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return &ValidationError{"body", "invalid JSON: " + err.Error()}
+	}
+	return v.validate(content.Schema, data, "body")
+}
+
+func isJSONMediaType(mediaType string) bool {
+	return mediaType == APPLICATION_JSON || strings.HasSuffix(mediaType, "+json")
+}
+
+// 按照参数的 Schema 把字符串转换成对应的类型
+func (v *specValidator) parseParameter(schema *Schema, values []string) (interface{}, error) {
+	schema = v.resolve(schema)
+	if schema.Type.Has("array") {
+		items := make([]interface{}, 0, len(values))
+		// 只出现一次时支持逗号分隔的写法
+		if len(values) == 1 {
+			values = strings.Split(values[0], ",")
+		}
+		for _, value := range values {
+			item := value
+			var parsed interface{} = item
+			if schema.Items != nil {
+				p, err := v.parseScalar(v.resolve(schema.Items), item)
+				if err != nil {
+					return nil, err
+				}
+				parsed = p
+			}
+			items = append(items, parsed)
+		}
+		return items, nil
+	}
+	return v.parseScalar(schema, values[0])
+}
+
+func (v *specValidator) parseScalar(schema *Schema, value string) (interface{}, error) {
+	switch {
+	case schema.Type.Has("integer"), schema.Type.Has("number"):
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be a number")
+		}
+		return n, nil
+	case schema.Type.Has("boolean"):
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("must be a boolean")
+		}
+		return b, nil
+	}
+	return value, nil
+}
+
+// 展开 $ref
+func (v *specValidator) resolve(schema *Schema) *Schema {
+	for depth := 0; schema != nil && schema.Ref != "" && depth < 32; depth++ {
+		name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+		if v.spec.Components == nil || v.spec.Components.Schemas[name] == nil {
+			return &Schema{}
+		}
+		schema = v.spec.Components.Schemas[name]
+	}
+	return schema
+}
+
+// 按照 JSON Schema 校验数据
+func (v *specValidator) validate(schema *Schema, data interface{}, field string) error {
+	schema = v.resolve(schema)
+	if schema == nil {
+		return nil
+	}
+	if data == nil {
+		if schema.Nullable || schema.Type.Has("null") || len(schema.Type) == 0 {
+			return nil
+		}
+		return &ValidationError{field, "must not be null"}
+	}
+	if len(schema.Type) != 0 && !v.matchType(schema.Type, data) {
+		return &ValidationError{field, "must be " + strings.Join(schema.Type, " or ")}
+	}
+	if len(schema.Enum) != 0 && !inEnum(schema.Enum, data) {
+		return &ValidationError{field, "must be one of the allowed values"}
+	}
+
+	switch value := data.(type) {
+	case string:
+		length := len([]rune(value))
+		if schema.MinLength != nil && length < *schema.MinLength {
+			return &ValidationError{field, fmt.Sprintf("length must be >= %d", *schema.MinLength)}
+		}
+		if schema.MaxLength != nil && length > *schema.MaxLength {
+			return &ValidationError{field, fmt.Sprintf("length must be <= %d", *schema.MaxLength)}
+		}
+		if schema.Pattern != "" {
+			re, err := v.pattern(schema.Pattern)
+			if err == nil && !re.MatchString(value) {
+				return &ValidationError{field, "must match pattern " + schema.Pattern}
+			}
+		}
+		if err := checkFormat(schema.Format, value); err != nil {
+			return &ValidationError{field, err.Error()}
+		}
+	case float64:
+		if schema.Minimum != nil && value < *schema.Minimum {
+			return &ValidationError{field, fmt.Sprintf("must be >= %v", *schema.Minimum)}
+		}
+		if schema.Maximum != nil && value > *schema.Maximum {
+			return &ValidationError{field, fmt.Sprintf("must be <= %v", *schema.Maximum)}
+		}
+	case []interface{}:
+		if schema.MinItems != nil && len(value) < *schema.MinItems {
+			return &ValidationError{field, fmt.Sprintf("must contain at least %d items", *schema.MinItems)}
+		}
+		if schema.MaxItems != nil && len(value) > *schema.MaxItems {
+			return &ValidationError{field, fmt.Sprintf("must contain at most %d items", *schema.MaxItems)}
+		}
+		if schema.Items != nil {
+			for i, item := range value {
+				if err := v.validate(schema.Items, item, field+"["+strconv.Itoa(i)+"]"); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range schema.Required {
+			if _, ok := value[name]; !ok {
+				return &ValidationError{field + "." + name, "is required"}
+			}
+		}
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if prop, ok := schema.Properties[key]; ok {
+				if err := v.validate(prop, value[key], field+"."+key); err != nil {
+					return err
+				}
+				continue
+			}
+			if schema.NoAdditionalProperties {
+				return &ValidationError{field + "." + key, "is not allowed"}
+			}
+			if schema.AdditionalProperties != nil {
+				if err := v.validate(schema.AdditionalProperties, value[key], field+"."+key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, sub := range schema.AllOf {
+		if err := v.validate(sub, data, field); err != nil {
+			return err
+		}
+	}
+	if len(schema.AnyOf) != 0 {
+		matched := false
+		for _, sub := range schema.AnyOf {
+			if v.validate(sub, data, field) == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return &ValidationError{field, "must match at least one schema in anyOf"}
+		}
+	}
+	if len(schema.OneOf) != 0 {
+		matched := 0
+		for _, sub := range schema.OneOf {
+			if v.validate(sub, data, field) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return &ValidationError{field, "must match exactly one schema in oneOf"}
+		}
+	}
+	return nil
+}
+
+func (v *specValidator) matchType(types SchemaType, data interface{}) bool {
+	for _, t := range types {
+		switch value := data.(type) {
+		case string:
+			if t == "string" {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case float64:
+			if t == "number" || t == "integer" && value == math.Trunc(value) {
+				return true
+			}
+		case []interface{}:
+			if t == "array" {
+				return true
+			}
+		case map[string]interface{}:
+			if t == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 正则表达式编译后缓存
+func (v *specValidator) pattern(expr string) (*regexp.Regexp, error) {
+	if re, ok := v.patterns.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	v.patterns.Store(expr, re)
+	return re, nil
+}
+
+func inEnum(enum []interface{}, data interface{}) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(data) {
+			return true
+		}
+	}
+	return false
+}
+
+// 校验常用的字符串格式，未知的格式直接放行
+func checkFormat(format string, value string) error {
+	switch format {
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return fmt.Errorf("must be a RFC 3339 date-time")
+		}
+	case "date":
+		if _, err := time.Parse(time.DateOnly, value); err != nil {
+			return fmt.Errorf("must be a date")
+		}
+	case "email":
+		if at := strings.LastIndex(value, "@"); at <= 0 || at == len(value)-1 {
+			return fmt.Errorf("must be an email address")
+		}
+	case "uuid":
+		if !uuidPattern.MatchString(value) {
+			return fmt.Errorf("must be a UUID")
+		}
+	}
+	return nil
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// 缓存响应，校验通过后再写出
+type bufferedResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// 执行路由函数并校验它的响应，不符合文档时把错误交给集中的错误处理函数
+func (v *specValidator) validateResponse(c Context, op *Operation, next HandlerFunc) {
+	resp := c.Response()
+	original := resp.Writer
+	buffer := &bufferedResponseWriter{header: original.Header()}
+	resp.Writer = buffer
+	finished := false
+	defer func() {
+		if finished {
+			return
+		}
+		// 路由函数 panic 时丢弃缓冲的响应并换回原来的 Writer，panic 继续交给外层的 Recovery
+		resp.Writer = original
+		resp.Status = http.StatusOK
+		resp.Size = 0
+		resp.Committed = false
+		buffer.header.Del("Content-Length")
+	}()
+	next(c)
+	finished = true
+	resp.Writer = original
+	resp.Committed = false
+
+	status := buffer.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if err := v.checkResponse(op, status, buffer.header.Get(CONTENT_TYPE), buffer.body.Bytes()); err != nil {
+		buffer.header.Del(CONTENT_TYPE)
+		buffer.header.Del("Content-Length")
+		resp.Status = http.StatusOK
+		resp.Size = 0
+		c.Error(NewHTTPError(http.StatusInternalServerError, "response does not match the OpenAPI document: "+err.Error()).SetInternal(err))
+		return
+	}
+	resp.WriteHeader(status)
+	resp.Size = 0
+	resp.Write(buffer.body.Bytes())
+}
+
+func (v *specValidator) checkResponse(op *Operation, status int, contentType string, body []byte) error {
+	code := strconv.Itoa(status)
+	spec, ok := op.Responses[code]
+	if !ok {
+		spec, ok = op.Responses[code[:1]+"XX"]
+	}
+	if !ok {
+		spec, ok = op.Responses["default"]
+	}
+	if !ok {
+		return &ValidationError{"response", "status " + code + " is not documented"}
+	}
+	if len(spec.Content) == 0 || len(body) == 0 {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	content, ok := spec.Content[mediaType]
+	if !ok {
+		return &ValidationError{"response", "undocumented content type " + strconv.Quote(mediaType)}
+	}
+	if content.Schema == nil || !isJSONMediaType(mediaType) {
+		return nil
+	}
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return &ValidationError{"response", "invalid JSON: " + err.Error()}
+	}
+	return v.validate(content.Schema, data, "response")
+}
@@ -0,0 +1,158 @@
+package capybara
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const validateSpec = `{
+  "openapi": "3.1.0",
+  "info": {"title": "test", "version": "1.0.0"},
+  "paths": {
+    "/users/{id}": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "minimum": 1}}],
+      "put": {
+        "parameters": [{"name": "tag", "in": "query", "schema": {"type": "array", "items": {"type": "string", "enum": ["a", "b"]}}}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}
+        },
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}}
+        }
+      }
+    },
+    "/users/me": {
+      "put": {"responses": {"204": {"description": "No Content"}}}
+    }
+  },
+  "components": {
+    "schemas": {
+      "User": {
+        "type": "object",
+        "required": ["name"],
+        "properties": {
+          "name": {"type": "string", "minLength": 2},
+          "email": {"type": ["string", "null"], "format": "email"}
+        },
+        "additionalProperties": false
+      }
+    }
+  }
+}`
+
+func newValidateServer(t *testing.T, validateResponses bool, response string) *capybara {
+	spec, err := LoadOpenAPI(strings.NewReader(validateSpec))
+	if err != nil {
+		t.Fatalf("读取文档失败: %v", err)
+	}
+	c := CreateCapybaraInstance()
+	c.Use(OpenAPIValidation(OpenAPIValidatorConfig{Spec: spec, RejectUnknown: true, ValidateResponses: validateResponses}))
+	c.PUT("/users/:id", func(ctx Context) {
+		ctx.JSONBlob(200, []byte(response))
+	})
+	return c
+}
+
+// 测试请求校验
+func TestOpenAPIValidateRequest(t *testing.T) {
+	c := newValidateServer(t, false, `{}`)
+	testCases := []struct {
+		target string
+		body   string
+		code   int
+	}{
+		{"/users/7?tag=a&tag=b", `{"name":"capy","email":null}`, 200},
+		{"/users/me", ``, 204},
+		{"/users/0", `{"name":"capy"}`, 400},
+		{"/users/abc", `{"name":"capy"}`, 400},
+		{"/users/7?tag=c", `{"name":"capy"}`, 400},
+		{"/users/7", ``, 400},
+		{"/users/7", `{"name":"c"}`, 400},
+		{"/users/7", `{"name":"capy","age":1}`, 400},
+		{"/users/7", `{"name":"capy","email":"capy"}`, 400},
+		{"/posts/7", `{}`, 404},
+	}
+	c.PUT("/users/me", func(ctx Context) { ctx.NoContent(204) })
+	for _, tc := range testCases {
+		r := httptest.NewRequest("PUT", tc.target, strings.NewReader(tc.body))
+		r.Header.Set(CONTENT_TYPE, APPLICATION_JSON)
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("请求校验异常: %s %s 期望 %d 得到 %d %s", tc.target, tc.body, tc.code, w.Code, w.Body.String())
+		}
+	}
+}
+
+// 测试校验之后路由函数仍然可以读取请求体
+func TestOpenAPIValidateBodyRestored(t *testing.T) {
+	spec, _ := LoadOpenAPI(strings.NewReader(validateSpec))
+	c := CreateCapybaraInstance()
+	c.Use(OpenAPIValidation(OpenAPIValidatorConfig{Spec: spec, BasePath: "/api"}))
+	var body struct {
+		Name string `json:"name"`
+	}
+	c.PUT("/api/users/:id", func(ctx Context) {
+		if err := ctx.Bind(&body); err != nil {
+			t.Errorf("读取请求体失败: %v", err)
+		}
+	})
+	r := httptest.NewRequest("PUT", "/api/users/7", strings.NewReader(`{"name":"capy"}`))
+	r.Header.Set(CONTENT_TYPE, APPLICATION_JSON)
+	c.ServeHTTP(httptest.NewRecorder(), r)
+	if body.Name != "capy" {
+		t.Errorf("请求体没有被放回: %q", body.Name)
+	}
+}
+
+// 测试响应校验
+func TestOpenAPIValidateResponse(t *testing.T) {
+	for response, code := range map[string]int{`{"name":"capy"}`: 200, `{"name":1}`: 500} {
+		c := newValidateServer(t, true, response)
+		r := httptest.NewRequest("PUT", "/users/7", strings.NewReader(`{"name":"capy"}`))
+		r.Header.Set(CONTENT_TYPE, APPLICATION_JSON)
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, r)
+		if w.Code != code {
+			t.Errorf("响应校验异常: %s 期望 %d 得到 %d", response, code, w.Code)
+		}
+		if code == 200 && w.Body.String() != response {
+			t.Errorf("响应体异常: %s", w.Body.String())
+		}
+	}
+}
+
+// 测试开启响应校验时路由函数 panic，外层的 Recovery 仍然可以发送 500
+func TestOpenAPIValidateResponsePanic(t *testing.T) {
+	spec, _ := LoadOpenAPI(strings.NewReader(validateSpec))
+	c := CreateCapybaraInstance()
+	c.Use(Recovery(), OpenAPIValidation(OpenAPIValidatorConfig{Spec: spec, ValidateResponses: true}))
+	c.PUT("/users/:id", func(ctx Context) {
+		ctx.Response().Header().Set(CONTENT_TYPE, APPLICATION_JSON)
+		panic("boom")
+	})
+	r := httptest.NewRequest("PUT", "/users/7", strings.NewReader(`{"name":"capy"}`))
+	r.Header.Set(CONTENT_TYPE, APPLICATION_JSON)
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, r)
+	if w.Code != 500 || w.Header().Get(CONTENT_TYPE) != APPLICATION_PROBLEM_JSON || w.Body.Len() == 0 {
+		t.Errorf("panic 后的响应异常: %d %s %q", w.Code, w.Header().Get(CONTENT_TYPE), w.Body.String())
+	}
+}
+
+// 测试请求体大小限制
+func TestOpenAPIValidateBodyLimit(t *testing.T) {
+	spec, _ := LoadOpenAPI(strings.NewReader(validateSpec))
+	c := CreateCapybaraInstance()
+	c.Use(OpenAPIValidation(OpenAPIValidatorConfig{Spec: spec, MaxBodySize: 16}))
+	c.PUT("/users/:id", func(ctx Context) {})
+	r := httptest.NewRequest("PUT", "/users/7", strings.NewReader(`{"name":"`+strings.Repeat("a", 32)+`"}`))
+	r.Header.Set(CONTENT_TYPE, APPLICATION_JSON)
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, r)
+	if w.Code != 413 {
+		t.Errorf("超长的请求体没有返回 413: %d", w.Code)
+	}
+}
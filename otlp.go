@@ -0,0 +1,233 @@
+package capybara
+
+import (
+	"bytes"
+	gocontext "context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// OTLP/HTTP 导出器的配置
+type OTLPConfig struct {
+	// 接收 Span 的地址，默认为 http://localhost:4318/v1/traces
+	Endpoint string
+	// 服务名，作为资源属性 service.name
+	ServiceName string
+	// 其他资源属性，例如 service.version、deployment.environment
+	Resource map[string]interface{}
+	// 额外的请求头，例如鉴权信息
+	Headers map[string]string
+	// 发送使用的客户端，默认超时 10 秒
+	Client *http.Client
+}
+
+// 以 OTLP/HTTP JSON 格式导出 Span，可以直接发送给 OpenTelemetry Collector
+type OTLPExporter struct {
+	config OTLPConfig
+}
+
+func NewOTLPExporter(config OTLPConfig) *OTLPExporter {
+	if config.Endpoint == "" {
+		config.Endpoint = "http://localhost:4318/v1/traces"
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &OTLPExporter{config: config}
+}
+
+// OTLP JSON 的数据结构，64 位整数按照 protobuf 的 JSON 映射编码为字符串
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	TraceState        string         `json:"traceState,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string         `json:"stringValue,omitempty"`
+	BoolValue   *bool           `json:"boolValue,omitempty"`
+	IntValue    *string         `json:"intValue,omitempty"`
+	DoubleValue *float64        `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpArrayValue `json:"arrayValue,omitempty"`
+}
+
+type otlpArrayValue struct {
+	Values []otlpValue `json:"values"`
+}
+
+func (e *OTLPExporter) ExportSpans(ctx gocontext.Context, spans []*Span) error {
+	resource := make(map[string]interface{}, len(e.config.Resource)+1)
+	for k, v := range e.config.Resource {
+		resource[k] = v
+	}
+	if e.config.ServiceName != "" {
+		resource["service.name"] = e.config.ServiceName
+	}
+	scope := otlpScopeSpans{Scope: otlpScope{Name: "capybara"}, Spans: make([]otlpSpan, 0, len(spans))}
+	for _, span := range spans {
+		scope.Spans = append(scope.Spans, newOTLPSpan(span))
+	}
+	body, err := json.Marshal(otlpTraces{[]otlpResourceSpans{{
+		Resource:   otlpResource{otlpAttributes(resource)},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(CONTENT_TYPE, APPLICATION_JSON)
+	for k, v := range e.config.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.config.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("otlp: export failed: %s %s", resp.Status, msg)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (e *OTLPExporter) Shutdown(ctx gocontext.Context) error {
+	e.config.Client.CloseIdleConnections()
+	return nil
+}
+
+func newOTLPSpan(span *Span) otlpSpan {
+	s := otlpSpan{
+		TraceID:           span.Context.TraceID.String(),
+		SpanID:            span.Context.SpanID.String(),
+		TraceState:        span.Context.TraceState,
+		Name:              span.Name,
+		Kind:              span.Kind,
+		StartTimeUnixNano: strconv.FormatInt(span.StartTime.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+		Attributes:        otlpAttributes(span.Attributes),
+		Status:            otlpStatus{span.Status, span.StatusMessage},
+	}
+	if span.Parent.IsValid() {
+		s.ParentSpanID = span.Parent.String()
+	}
+	for _, event := range span.Events {
+		s.Events = append(s.Events, otlpEvent{
+			TimeUnixNano: strconv.FormatInt(event.Time.UnixNano(), 10),
+			Name:         event.Name,
+			Attributes:   otlpAttributes(event.Attributes),
+		})
+	}
+	return s
+}
+
+// 按键排序，输出是确定的
+func otlpAttributes(attributes map[string]interface{}) []otlpKeyValue {
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kvs := make([]otlpKeyValue, 0, len(keys))
+	for _, k := range keys {
+		kvs = append(kvs, otlpKeyValue{k, newOTLPValue(attributes[k])})
+	}
+	return kvs
+}
+
+func newOTLPValue(value interface{}) otlpValue {
+	switch v := value.(type) {
+	case string:
+		return otlpValue{StringValue: &v}
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case int:
+		return otlpInt(int64(v))
+	case int32:
+		return otlpInt(int64(v))
+	case int64:
+		return otlpInt(v)
+	case uint32:
+		return otlpInt(int64(v))
+	case float32:
+		f := float64(v)
+		return otlpValue{DoubleValue: &f}
+	case float64:
+		return otlpValue{DoubleValue: &v}
+	case []string:
+		values := make([]otlpValue, len(v))
+		for i := range v {
+			values[i] = newOTLPValue(v[i])
+		}
+		return otlpValue{ArrayValue: &otlpArrayValue{values}}
+	case []interface{}:
+		values := make([]otlpValue, len(v))
+		for i := range v {
+			values[i] = newOTLPValue(v[i])
+		}
+		return otlpValue{ArrayValue: &otlpArrayValue{values}}
+	}
+	s := fmt.Sprint(value)
+	return otlpValue{StringValue: &s}
+}
+
+func otlpInt(v int64) otlpValue {
+	s := strconv.FormatInt(v, 10)
+	return otlpValue{IntValue: &s}
+}
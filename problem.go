@@ -0,0 +1,334 @@
+package capybara
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// RFC 9457 Problem Details
+const (
+	APPLICATION_PROBLEM_JSON = "application/problem+json"
+	APPLICATION_PROBLEM_XML  = "application/problem+xml"
+	// problem+xml 的命名空间
+	PROBLEM_XML_NAMESPACE = "urn:ietf:rfc:7807"
+	// 没有更具体的类型时使用，此时 title 应为状态码的标准描述
+	PROBLEM_ABOUT_BLANK = "about:blank"
+)
+
+// RFC 9457 定义的错误响应
+//
+//	return capybara.NewProblem(403, "余额不足").With("balance", 30)
+type Problem struct {
+	// 问题类型的 URI，为空时根据状态码查找注册的类型，找不到时为 about:blank
+	Type   string
+	Title  string
+	Status int
+	// 针对这一次错误的说明
+	Detail string
+	// 这一次错误的 URI，为空时使用请求路径
+	Instance string
+	// 扩展成员，和标准成员同级输出
+	Extensions map[string]interface{}
+}
+
+// 创建一个 Problem，detail 可以省略
+func NewProblem(status int, detail ...string) *Problem {
+	p := &Problem{Status: status}
+	if len(detail) > 0 {
+		p.Detail = detail[0]
+	}
+	return p
+}
+
+// 添加扩展成员
+func (p *Problem) With(key string, value interface{}) *Problem {
+	if p.Extensions == nil {
+		p.Extensions = make(map[string]interface{})
+	}
+	p.Extensions[key] = value
+	return p
+}
+
+func (p *Problem) Error() string {
+	title := p.Title
+	if title == "" {
+		title = http.StatusText(p.Status)
+	}
+	if p.Detail == "" {
+		return title
+	}
+	return title + ": " + p.Detail
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	members := make(map[string]interface{}, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		members[k] = v
+	}
+	members["type"] = p.Type
+	if p.Type == "" {
+		members["type"] = PROBLEM_ABOUT_BLANK
+	}
+	if p.Title != "" {
+		members["title"] = p.Title
+	}
+	if p.Status != 0 {
+		members["status"] = p.Status
+	}
+	if p.Detail != "" {
+		members["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		members["instance"] = p.Instance
+	}
+	return json.Marshal(members)
+}
+
+func (p *Problem) UnmarshalJSON(b []byte) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(b, &members); err != nil {
+		return err
+	}
+	*p = Problem{}
+	for k, raw := range members {
+		var err error
+		switch k {
+		case "type":
+			err = json.Unmarshal(raw, &p.Type)
+		case "title":
+			err = json.Unmarshal(raw, &p.Title)
+		case "status":
+			err = json.Unmarshal(raw, &p.Status)
+		case "detail":
+			err = json.Unmarshal(raw, &p.Detail)
+		case "instance":
+			err = json.Unmarshal(raw, &p.Instance)
+		default:
+			var v interface{}
+			if err = json.Unmarshal(raw, &v); err == nil {
+				p.With(k, v)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("problem: member %q: %w", k, err)
+		}
+	}
+	return nil
+}
+
+// 按照 RFC 9457 附录 B 编码为 XML，数组的元素使用 <i> 表示
+func (p *Problem) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start = xml.StartElement{Name: xml.Name{Space: PROBLEM_XML_NAMESPACE, Local: "problem"}}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	typ := p.Type
+	if typ == "" {
+		typ = PROBLEM_ABOUT_BLANK
+	}
+	members := []struct {
+		name  string
+		value interface{}
+	}{{"type", typ}, {"title", p.Title}, {"status", p.Status}, {"detail", p.Detail}, {"instance", p.Instance}}
+	for _, m := range members {
+		if m.value == "" || m.value == 0 {
+			continue
+		}
+		if err := encodeProblemXML(e, m.name, m.value); err != nil {
+			return err
+		}
+	}
+	keys := make([]string, 0, len(p.Extensions))
+	for k := range p.Extensions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		// 扩展成员先转成 JSON 的数据模型，结构体按 json tag 输出
+		b, err := json.Marshal(p.Extensions[k])
+		if err != nil {
+			return err
+		}
+		var v interface{}
+		json.Unmarshal(b, &v)
+		if err := encodeProblemXML(e, k, v); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+func encodeProblemXML(e *xml.Encoder, name string, value interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		for _, item := range v {
+			if err := encodeProblemXML(e, "i", item); err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(start.End())
+	case map[string]interface{}:
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := encodeProblemXML(e, k, v[k]); err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(start.End())
+	}
+	return e.EncodeElement(fmt.Sprint(value), start)
+}
+
+// 注册的问题类型
+type ProblemType struct {
+	// 问题类型的 URI，例如 https://example.com/problems/out-of-credit
+	URI   string
+	Title string
+	// 对应的状态码
+	Status int
+}
+
+type problemError struct {
+	target error
+	typ    ProblemType
+}
+
+// 按状态码注册问题类型，该状态码的错误响应都使用这个类型
+//
+//	c.RegisterProblemType(capybara.ProblemType{URI: "https://example.com/problems/not-found", Title: "资源不存在", Status: 404})
+func (c *capybara) RegisterProblemType(typ ProblemType) {
+	if c.problemTypes == nil {
+		c.problemTypes = make(map[int]ProblemType)
+	}
+	c.problemTypes[typ.Status] = typ
+}
+
+// 按错误注册问题类型，使用 errors.Is 匹配，包括 HTTPError 的内部错误
+//
+//	c.RegisterProblemError(ErrOutOfCredit, capybara.ProblemType{URI: "https://example.com/problems/out-of-credit", Title: "余额不足", Status: 403})
+func (c *capybara) RegisterProblemError(target error, typ ProblemType) {
+	c.problemErrors = append(c.problemErrors, problemError{target, typ})
+}
+
+// 把错误转换成 Problem
+//
+// 500 及以上的普通错误不会把错误信息发送给客户端
+func (c *capybara) problemOf(err error) *Problem {
+	var p *Problem
+	if errors.As(err, &p) {
+		return p
+	}
+
+	p = NewProblem(http.StatusInternalServerError)
+	var he *HTTPError
+	if errors.As(err, &he) {
+		p.Status = he.Code
+		switch msg := he.Message.(type) {
+		case string:
+			if msg != http.StatusText(he.Code) {
+				p.Detail = msg
+			}
+		case error:
+			p.Detail = msg.Error()
+		case nil:
+		default:
+			p.With("errors", msg)
+		}
+	}
+	if c == nil {
+		return p
+	}
+	for _, pe := range c.problemErrors {
+		if !errors.Is(err, pe.target) {
+			continue
+		}
+		p.Type, p.Title = pe.typ.URI, pe.typ.Title
+		if pe.typ.Status != 0 {
+			p.Status = pe.typ.Status
+		}
+		if he == nil && p.Status < http.StatusInternalServerError {
+			p.Detail = err.Error()
+		}
+		break
+	}
+	return p
+}
+
+// 补全类型、标题与 instance 后发送，根据 Accept 选择 JSON 或 XML
+func (c *capybara) sendProblem(w http.ResponseWriter, r *http.Request, problem *Problem) {
+	copied := *problem
+	p := &copied
+	if p.Status == 0 {
+		p.Status = http.StatusInternalServerError
+	}
+	if p.Type == "" && c != nil {
+		if typ, ok := c.problemTypes[p.Status]; ok {
+			p.Type, p.Title = typ.URI, typ.Title
+		}
+	}
+	if (p.Type == "" || p.Type == PROBLEM_ABOUT_BLANK) && p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	if p.Instance == "" && r != nil {
+		p.Instance = r.URL.Path
+	}
+
+	accept := ""
+	if r != nil {
+		accept = r.Header.Get(ACCEPT)
+	}
+	w.Header().Add(VARY, ACCEPT)
+	if prefersProblemXML(accept) {
+		b, err := xml.Marshal(p)
+		if err == nil {
+			w.Header().Set(CONTENT_TYPE, APPLICATION_PROBLEM_XML)
+			w.WriteHeader(p.Status)
+			w.Write(append([]byte(xml.Header), b...))
+			return
+		}
+	}
+	w.Header().Set(CONTENT_TYPE, APPLICATION_PROBLEM_JSON)
+	w.WriteHeader(p.Status)
+	c.jsonSerializer().Serialize(w, p, "")
+}
+
+// 客户端明确更偏好 XML 时才使用 problem+xml
+func prefersProblemXML(accept string) bool {
+	if strings.TrimSpace(accept) == "" {
+		return false
+	}
+	specs := parseAccept(accept)
+	quality := func(mediaTypes ...string) float64 {
+		q := 0.0
+		for _, mediaType := range mediaTypes {
+			q = max(q, mediaTypeQuality(specs, mediaType))
+		}
+		return q
+	}
+	return quality(APPLICATION_PROBLEM_XML, APPLICATION_XML, TEXT_XML) >
+		quality(APPLICATION_PROBLEM_JSON, APPLICATION_JSON)
+}
+
+// 以 Problem Details 的格式发送错误响应
+func (c *context) Problem(p *Problem) error {
+	c.capa.sendProblem(c.w, c.r, p)
+	return nil
+}
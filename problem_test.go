@@ -0,0 +1,94 @@
+package capybara
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serveProblem(c *capybara, method string, target string, accept string) (*httptest.ResponseRecorder, *Problem) {
+	r := httptest.NewRequest(method, target, nil)
+	if accept != "" {
+		r.Header.Set(ACCEPT, accept)
+	}
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, r)
+	p := new(Problem)
+	json.Unmarshal(w.Body.Bytes(), p)
+	return w, p
+}
+
+// 测试默认的 404 与 405
+func TestProblemDefaults(t *testing.T) {
+	c := CreateCapybaraInstance()
+	c.GET("/users/:id", func(ctx Context) {})
+
+	w, p := serveProblem(c, "GET", "/posts", "")
+	if w.Code != 404 || w.Header().Get(CONTENT_TYPE) != APPLICATION_PROBLEM_JSON {
+		t.Errorf("404 响应异常: %d %s", w.Code, w.Header().Get(CONTENT_TYPE))
+	}
+	if p.Type != PROBLEM_ABOUT_BLANK || p.Title != "Not Found" || p.Status != 404 || p.Instance != "/posts" {
+		t.Errorf("404 内容异常: %+v", p)
+	}
+
+	w, p = serveProblem(c, "DELETE", "/users/1", "")
+	if w.Code != 405 || w.Header().Get(ALLOW) != "GET, HEAD" || p.Status != 405 {
+		t.Errorf("405 响应异常: %d %q %+v", w.Code, w.Header().Get(ALLOW), p)
+	}
+}
+
+var errOutOfCredit = errors.New("out of credit")
+
+// 测试注册问题类型以及错误的转换
+func TestProblemTypes(t *testing.T) {
+	c := CreateCapybaraInstance()
+	c.RegisterProblemType(ProblemType{URI: "https://example.com/problems/not-found", Title: "资源不存在", Status: 404})
+	c.RegisterProblemError(errOutOfCredit, ProblemType{URI: "https://example.com/problems/out-of-credit", Title: "余额不足", Status: 403})
+	c.GET("/credit", func(ctx Context) {
+		ctx.Error(errOutOfCredit)
+	})
+	c.GET("/problem", func(ctx Context) {
+		ctx.Error(NewProblem(409, "版本冲突").With("version", 3))
+	})
+	c.GET("/internal", func(ctx Context) {
+		ctx.Error(errors.New("database password is wrong"))
+	})
+	c.GET("/http", func(ctx Context) {
+		ctx.Error(NewHTTPError(400, "name is required"))
+	})
+
+	if _, p := serveProblem(c, "GET", "/missing", ""); p.Type != "https://example.com/problems/not-found" || p.Title != "资源不存在" {
+		t.Errorf("按状态码注册的类型没有生效: %+v", p)
+	}
+	if w, p := serveProblem(c, "GET", "/credit", ""); w.Code != 403 || p.Type != "https://example.com/problems/out-of-credit" || p.Detail != "out of credit" {
+		t.Errorf("按错误注册的类型没有生效: %d %+v", w.Code, p)
+	}
+	if w, p := serveProblem(c, "GET", "/problem", ""); w.Code != 409 || p.Detail != "版本冲突" || p.Extensions["version"] != 3.0 {
+		t.Errorf("Problem 发送异常: %d %+v", w.Code, p)
+	}
+	if w, p := serveProblem(c, "GET", "/internal", ""); w.Code != 500 || p.Detail != "" {
+		t.Errorf("内部错误被泄露: %d %+v", w.Code, p)
+	}
+	if w, p := serveProblem(c, "GET", "/http", ""); w.Code != 400 || p.Detail != "name is required" {
+		t.Errorf("HTTPError 转换异常: %d %+v", w.Code, p)
+	}
+}
+
+// 测试 problem+xml
+func TestProblemXML(t *testing.T) {
+	c := CreateCapybaraInstance()
+	c.GET("/problem", func(ctx Context) {
+		ctx.Problem(NewProblem(409).With("versions", []int{1, 2}))
+	})
+	w, _ := serveProblem(c, "GET", "/problem", "application/xml, application/json;q=0.5")
+	if w.Header().Get(CONTENT_TYPE) != APPLICATION_PROBLEM_XML {
+		t.Fatalf("没有协商为 XML: %s", w.Header().Get(CONTENT_TYPE))
+	}
+	expected := `<problem xmlns="urn:ietf:rfc:7807"><type>about:blank</type><title>Conflict</title><status>409</status>` +
+		`<instance>/problem</instance><versions><i>1</i><i>2</i></versions></problem>`
+	if !strings.HasSuffix(w.Body.String(), expected) {
+		t.Errorf("XML 内容异常: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,214 @@
+package capybara
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// 代理相关的请求头
+const (
+	HEADER_FORWARDED         = "Forwarded"
+	HEADER_X_FORWARDED_FOR   = "X-Forwarded-For"
+	HEADER_X_FORWARDED_PROTO = "X-Forwarded-Proto"
+	HEADER_X_FORWARDED_HOST  = "X-Forwarded-Host"
+	HEADER_X_FORWARDED_SSL   = "X-Forwarded-Ssl"
+	HEADER_X_REAL_IP         = "X-Real-Ip"
+)
+
+// 默认信任的代理网段：回环地址、链路本地地址以及私有网段
+var defaultTrustedProxies = []string{
+	"127.0.0.0/8", "::1/128",
+	"169.254.0.0/16", "fe80::/10",
+	"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7",
+}
+
+// 设置受信任的代理，可以是单个 IP 也可以是 CIDR 网段
+//
+// 只有直接连接方属于受信任的代理时，才会读取 Forwarded / X-Forwarded-* 请求头，
+// 不传任何参数则不信任任何代理
+func (c *capybara) SetTrustedProxies(proxies ...string) error {
+	nets, err := parseTrustedProxies(proxies)
+	if err != nil {
+		return err
+	}
+	c.trustedProxies = nets
+	return nil
+}
+
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: p}
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// 判断一个 IP 是否属于受信任的代理
+func (c *capybara) isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range c.trustedProxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// 从 RemoteAddr 中取出不带端口的 IP
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// 解析 RFC 7239 的 Forwarded 请求头
+//
+//	Forwarded: for=192.0.2.60;proto=http;by=203.0.113.43, for="[2001:db8::1]:4711"
+//
+// 每一跳为一个 map，按照从客户端到服务端的顺序返回
+func parseForwarded(header string) []map[string]string {
+	elements := make([]map[string]string, 0)
+	for _, element := range strings.Split(header, ",") {
+		pairs := make(map[string]string)
+		for _, pair := range strings.Split(element, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok {
+				continue
+			}
+			pairs[strings.ToLower(key)] = strings.Trim(value, `"`)
+		}
+		if len(pairs) != 0 {
+			elements = append(elements, pairs)
+		}
+	}
+	return elements
+}
+
+// 去掉 Forwarded 中 for 字段可能带有的端口和方括号
+func forwardedNodeIP(node string) string {
+	if strings.HasPrefix(node, "[") {
+		if end := strings.Index(node, "]"); end != -1 {
+			return node[1:end]
+		}
+	}
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return node
+}
+
+// 返回代理链中记录的客户端地址列表，顺序为从客户端到服务端
+func (c *context) forwardedFor() []string {
+	ips := make([]string, 0)
+	for _, element := range parseForwarded(joinHeaderValues(c.r, HEADER_FORWARDED)) {
+		if node, ok := element["for"]; ok {
+			ips = append(ips, forwardedNodeIP(node))
+		}
+	}
+	if len(ips) != 0 {
+		return ips
+	}
+	if ips = headerValues(c.r, HEADER_X_FORWARDED_FOR); len(ips) != 0 {
+		return ips
+	}
+	if ip := c.r.Header.Get(HEADER_X_REAL_IP); ip != "" {
+		ips = append(ips, strings.TrimSpace(ip))
+	}
+	return ips
+}
+
+// 直接连接方是否为受信任的代理
+func (c *context) fromTrustedProxy() bool {
+	return c.capa != nil && c.capa.isTrustedProxy(remoteIP(c.r))
+}
+
+// 返回受信任的代理记录的某个 Forwarded 字段
+//
+// 与 RealIP 一样从右往左查找，遇到 for 不是受信任代理的一跳后停止，
+// 更左边的内容可能由客户端伪造，不会被采信
+func (c *context) forwardedValue(key string) string {
+	elements := parseForwarded(joinHeaderValues(c.r, HEADER_FORWARDED))
+	value := ""
+	for i := len(elements) - 1; i >= 0; i-- {
+		if v := elements[i][key]; v != "" {
+			value = v
+		}
+		if !c.capa.isTrustedProxy(forwardedNodeIP(elements[i]["for"])) {
+			break
+		}
+	}
+	return value
+}
+
+// 返回受信任的代理通过 X-Forwarded-* 记录的值
+//
+// 每一个受信任的代理向请求头追加一个值，跳数由 X-Forwarded-For 末尾连续的受信任代理决定，
+// 取最靠近客户端的受信任代理追加的值
+func (c *context) xForwardedValue(key string) string {
+	values := headerValues(c.r, key)
+	if len(values) == 0 {
+		return ""
+	}
+	hops := 1
+	ips := headerValues(c.r, HEADER_X_FORWARDED_FOR)
+	for i := len(ips) - 1; i > 0 && c.capa.isTrustedProxy(ips[i]); i-- {
+		hops++
+	}
+	if hops > len(values) {
+		return values[0]
+	}
+	return values[len(values)-hops]
+}
+
+// 取出请求头所有行中逗号分隔的值，重复的请求头按出现的顺序合并
+func headerValues(r *http.Request, key string) []string {
+	values := make([]string, 0)
+	for _, line := range r.Header.Values(key) {
+		for _, value := range strings.Split(line, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+func joinHeaderValues(r *http.Request, key string) string {
+	return strings.Join(r.Header.Values(key), ",")
+}
+
+// 主机名中不能出现路径、用户信息以及空白等字符
+func validHost(host string) bool {
+	if host == "" {
+		return false
+	}
+	for i := 0; i < len(host); i++ {
+		if b := host[i]; b <= ' ' || b >= 0x7f || strings.IndexByte(`/\@?#"`, b) != -1 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,6 @@
+//go:build race
+
+package capybara
+
+// 开启 -race 时默认使用调试模式，检查请求结束后对 Context 的使用
+const raceEnabled = true
@@ -0,0 +1,226 @@
+package capybara
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrRendererNotRegistered = errors.New("renderer not registered")
+
+// 模板渲染器，可以接入任意的模板引擎
+type Renderer interface {
+	Render(w io.Writer, name string, data interface{}, c Context) error
+}
+
+// 使用实例上注册的渲染器渲染模板
+//
+//	c.Render(200, "users/show", user)
+func (c *context) Render(code int, name string, data interface{}) error {
+	if c.capa == nil || c.capa.Renderer == nil {
+		return ErrRendererNotRegistered
+	}
+	// 先渲染到缓冲区，避免模板出错时发送半个页面
+	buf := new(bytes.Buffer)
+	if err := c.capa.Renderer.Render(buf, name, data, c); err != nil {
+		return err
+	}
+	return c.HTML(code, buf.String())
+}
+
+// 内置 html/template 渲染器的配置
+type TemplateConfig struct {
+	// 模板所在的本地目录，Filesystem 为空时使用
+	Root string
+	// 模板所在的文件系统，可以是 embed.FS
+	Filesystem fs.FS
+	// 模板文件的后缀，默认为 .html
+	Extension string
+	// 布局模板所在的目录，默认为 layouts
+	LayoutsDir string
+	// 局部模板所在的目录，默认为 partials
+	PartialsDir string
+	// 默认使用的布局，例如 layouts/base，为空时直接渲染页面模板；
+	// 只有定义了 content 的页面才套用布局
+	Layout string
+	// 自定义模板函数
+	Funcs template.FuncMap
+	// 模板文件变化时自动重新加载，开发环境使用
+	Reload bool
+}
+
+// 基于 html/template 的渲染器
+//
+// 模板以相对路径去掉后缀命名，例如 users/show.html 的名字为 users/show。
+// 每个页面都可以使用所有的布局和局部模板：
+//
+//	layouts/base.html: <body>{{block "content" .}}{{end}}</body>
+//	partials/nav.html: <nav>...</nav>
+//	users/show.html:   {{define "content"}}{{template "partials/nav" .}}{{.Name}}{{end}}
+type HTMLRenderer struct {
+	config    TemplateConfig
+	mu        sync.RWMutex
+	templates map[string]*template.Template
+	// 定义了 content 的页面，渲染时套用布局
+	layoutPages map[string]bool
+	// 加载时每个模板文件的修改时间
+	files map[string]time.Time
+}
+
+// 创建 html/template 渲染器并加载所有模板
+func NewHTMLRenderer(config TemplateConfig) (*HTMLRenderer, error) {
+	if config.Filesystem == nil {
+		if config.Root == "" {
+			config.Root = "."
+		}
+		config.Filesystem = os.DirFS(config.Root)
+	}
+	if config.Extension == "" {
+		config.Extension = ".html"
+	}
+	if config.LayoutsDir == "" {
+		config.LayoutsDir = "layouts"
+	}
+	if config.PartialsDir == "" {
+		config.PartialsDir = "partials"
+	}
+	r := &HTMLRenderer{config: config}
+	if err := r.Load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// 渲染模板，开启 Reload 时模板文件变化后会先重新加载
+func (r *HTMLRenderer) Render(w io.Writer, name string, data interface{}, c Context) error {
+	if r.config.Reload {
+		files, err := r.templateFiles()
+		if err != nil {
+			return err
+		}
+		// 新增、删除或者修改时间变化（包括恢复成更旧的文件）都会重新加载
+		r.mu.RLock()
+		changed := !maps.EqualFunc(files, r.files, time.Time.Equal)
+		r.mu.RUnlock()
+		if changed {
+			if err := r.Load(); err != nil {
+				return err
+			}
+		}
+	}
+
+	r.mu.RLock()
+	tmpl, ok := r.templates[name]
+	withLayout := r.layoutPages[name]
+	r.mu.RUnlock()
+	if !ok {
+		return errors.New("template " + name + " not found")
+	}
+	if withLayout && r.config.Layout != "" && tmpl.Lookup(r.config.Layout) != nil {
+		return tmpl.ExecuteTemplate(w, r.config.Layout, data)
+	}
+	return tmpl.ExecuteTemplate(w, name, data)
+}
+
+// 重新加载所有模板
+func (r *HTMLRenderer) Load() error {
+	files, err := r.templateFiles()
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(files))
+	for file := range files {
+		names = append(names, file)
+	}
+	sort.Strings(names)
+
+	// 布局和局部模板组成公共的模板集合，每个页面在它的副本上解析
+	base := template.New("").Funcs(r.config.Funcs)
+	pages := make([]string, 0)
+	for _, file := range names {
+		if r.isShared(file) {
+			if err := r.parseFile(base, file); err != nil {
+				return err
+			}
+			continue
+		}
+		pages = append(pages, file)
+	}
+
+	templates := make(map[string]*template.Template, len(files))
+	layoutPages := make(map[string]bool)
+	for _, file := range pages {
+		tmpl, err := base.Clone()
+		if err != nil {
+			return err
+		}
+		if err := r.parseFile(tmpl, file); err != nil {
+			return err
+		}
+		name := r.templateName(file)
+		templates[name] = tmpl
+		// 布局中的 {{block "content"}} 也会定义 content，只认页面文件自身的定义
+		if t := tmpl.Lookup("content"); t != nil && t.Tree != nil && t.Tree.ParseName == name {
+			layoutPages[name] = true
+		}
+	}
+	for _, file := range names {
+		if r.isShared(file) {
+			templates[r.templateName(file)] = base
+		}
+	}
+
+	r.mu.Lock()
+	r.templates = templates
+	r.layoutPages = layoutPages
+	r.files = files
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *HTMLRenderer) parseFile(t *template.Template, file string) error {
+	b, err := fs.ReadFile(r.config.Filesystem, file)
+	if err != nil {
+		return err
+	}
+	_, err = t.New(r.templateName(file)).Parse(string(b))
+	return err
+}
+
+// 是否为布局或局部模板
+func (r *HTMLRenderer) isShared(file string) bool {
+	return strings.HasPrefix(file, r.config.LayoutsDir+"/") || strings.HasPrefix(file, r.config.PartialsDir+"/")
+}
+
+func (r *HTMLRenderer) templateName(file string) string {
+	return strings.TrimSuffix(file, r.config.Extension)
+}
+
+// 返回所有模板文件以及它们的修改时间
+func (r *HTMLRenderer) templateFiles() (map[string]time.Time, error) {
+	files := make(map[string]time.Time)
+	err := fs.WalkDir(r.config.Filesystem, ".", func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || path.Ext(file) != r.config.Extension {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files[file] = info.ModTime()
+		return nil
+	})
+	return files, err
+}
@@ -0,0 +1,81 @@
+package capybara
+
+import (
+	"html/template"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// 测试布局、局部模板与自定义函数
+func TestHTMLRenderer(t *testing.T) {
+	fsys := fstest.MapFS{
+		"layouts/base.html": {Data: []byte(`<body>{{block "content" .}}{{end}}</body>`)},
+		"partials/nav.html": {Data: []byte(`<nav>{{upper .Site}}</nav>`)},
+		"users/show.html":   {Data: []byte(`{{define "content"}}{{template "partials/nav" .}}{{.Name}}{{end}}`)},
+		"plain.html":        {Data: []byte(`<p>{{.Name}}</p>`)},
+	}
+	renderer, err := NewHTMLRenderer(TemplateConfig{
+		Filesystem: fsys,
+		Layout:     "layouts/base",
+		Funcs:      template.FuncMap{"upper": strings.ToUpper},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := CreateCapybaraInstance()
+	c.Renderer = renderer
+
+	ctx, w := newTestContext(c, httptest.NewRequest("GET", "/", nil))
+	err = ctx.Render(200, "users/show", map[string]string{"Site": "capy", "Name": "<b>"})
+	if err != nil || w.Body.String() != "<body><nav>CAPY</nav>&lt;b&gt;</body>" {
+		t.Errorf("模板渲染异常: %v %s", err, w.Body.String())
+	}
+
+	// 没有定义 content 的页面不套用布局
+	ctx, w = newTestContext(c, httptest.NewRequest("GET", "/", nil))
+	err = ctx.Render(200, "plain", map[string]string{"Name": "capy"})
+	if err != nil || w.Body.String() != "<p>capy</p>" {
+		t.Errorf("没有 content 的页面渲染异常: %v %s", err, w.Body.String())
+	}
+
+	ctx, _ = newTestContext(CreateCapybaraInstance(), httptest.NewRequest("GET", "/", nil))
+	if err := ctx.Render(200, "users/show", nil); err != ErrRendererNotRegistered {
+		t.Error("未注册渲染器时未报错")
+	}
+}
+
+// 测试模板变化后自动重新加载
+func TestHTMLRendererReload(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": {Data: []byte(`v1`), ModTime: time.Unix(1, 0)},
+	}
+	renderer, err := NewHTMLRenderer(TemplateConfig{Filesystem: fsys, Reload: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys["index.html"] = &fstest.MapFile{Data: []byte(`v2`), ModTime: time.Unix(2, 0)}
+
+	var b strings.Builder
+	if err := renderer.Render(&b, "index", nil, nil); err != nil || b.String() != "v2" {
+		t.Errorf("模板未重新加载: %v %s", err, b.String())
+	}
+
+	// 恢复成更旧的文件
+	fsys["index.html"] = &fstest.MapFile{Data: []byte(`v0`), ModTime: time.Unix(0, 0)}
+	b.Reset()
+	if err := renderer.Render(&b, "index", nil, nil); err != nil || b.String() != "v0" {
+		t.Errorf("恢复旧文件后模板未重新加载: %v %s", err, b.String())
+	}
+
+	// 删除模板
+	fsys["about.html"] = &fstest.MapFile{Data: []byte(`about`), ModTime: time.Unix(0, 0)}
+	renderer.Render(io.Discard, "index", nil, nil)
+	delete(fsys, "about.html")
+	if err := renderer.Render(io.Discard, "about", nil, nil); err == nil {
+		t.Error("删除的模板仍然可以渲染")
+	}
+}
@@ -0,0 +1,138 @@
+package capybara
+
+import (
+	gocontext "context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+)
+
+const (
+	HEADER_X_REQUEST_ID = "X-Request-Id"
+)
+
+// 请求 ID 中间件的配置
+type RequestIDConfig struct {
+	// 读取和返回请求 ID 的请求头，默认为 X-Request-Id
+	Header string
+	// 生成新的请求 ID，默认生成 UUID v4
+	Generator func() string
+	// 检查客户端传入的请求 ID，不通过时重新生成；默认只接受 128 个字符以内的字母、数字与 -_.:+/=
+	Validator func(id string) bool
+}
+
+// 读取或生成请求 ID，写入响应头并保存到 RequestIDKey，Context.Logger 会带上它
+//
+//	c.Use(capybara.RequestID(capybara.RequestIDConfig{}))
+func RequestID(config RequestIDConfig) Middlewares {
+	if config.Header == "" {
+		config.Header = HEADER_X_REQUEST_ID
+	}
+	if config.Generator == nil {
+		config.Generator = newUUID
+	}
+	if config.Validator == nil {
+		config.Validator = validRequestID
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx Context) {
+			id := ctx.Request().Header.Get(config.Header)
+			if id == "" || !config.Validator(id) {
+				id = config.Generator()
+			}
+			ctx.Response().Header().Set(config.Header, id)
+			// 保存在请求的 context 中，发往下游的请求也能取到
+			ctx.SetContext(gocontext.WithValue(ctx.Request().Context(), RequestIDKey, id))
+			next(ctx)
+		}
+	}
+}
+
+// 生成随机的 UUID v4
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// 只接受不会破坏日志格式的字符
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		ch := id[i]
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9':
+		case ch == '-', ch == '_', ch == '.', ch == ':', ch == '+', ch == '/', ch == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// 框架提供的 HTTP 客户端使用的 Transport，把当前请求的 ID 传递给下游服务
+//
+// 当前请求被 Tracing 追踪时，为每个请求创建一个 CLIENT Span 并带上 traceparent 与 tracestate
+//
+// 发出的请求需要使用当前请求的 context；Context 在路由函数返回后会被复用，不能直接使用：
+//
+//	req, _ := http.NewRequestWithContext(ctx.Request().Context(), "GET", "http://inventory/items", nil)
+//	resp, err := client.Do(req)
+type Transport struct {
+	// 底层的 RoundTripper，默认为 http.DefaultTransport
+	Base http.RoundTripper
+	// 请求 ID 的请求头，默认为 X-Request-Id
+	RequestIDHeader string
+}
+
+// 创建传递请求 ID 的 HTTP 客户端
+func NewHTTPClient() *http.Client {
+	return &http.Client{Transport: &Transport{}}
+}
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	header := t.RequestIDHeader
+	if header == "" {
+		header = HEADER_X_REQUEST_ID
+	}
+	// RoundTripper 不能修改传入的请求
+	r = r.Clone(r.Context())
+	if id := requestIDFromContext(r.Context()); id != "" && r.Header.Get(header) == "" {
+		r.Header.Set(header, id)
+	}
+	parent := SpanFromContext(r.Context())
+	if parent == nil || r.Header.Get(HEADER_TRACEPARENT) != "" {
+		return base.RoundTrip(r)
+	}
+
+	span := parent.tracer.newSpan(r.Method, SPAN_KIND_CLIENT, parent.Context)
+	defer span.End()
+	span.SetAttribute("http.request.method", r.Method)
+	span.SetAttribute("url.full", r.URL.Redacted())
+	span.SetAttribute("server.address", r.URL.Hostname())
+	injectSpanContext(r.Header, span.Context)
+	resp, err := base.RoundTrip(r)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetAttribute("http.response.status_code", resp.StatusCode)
+	// 客户端把 4xx 与 5xx 都记为错误
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(SPAN_STATUS_ERROR, resp.Status)
+	}
+	return resp, nil
+}
+
+func requestIDFromContext(ctx gocontext.Context) string {
+	id, _ := ctx.Value(RequestIDKey).(string)
+	return id
+}
@@ -0,0 +1,75 @@
+package capybara
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// 测试读取、校验与生成请求 ID
+func TestRequestID(t *testing.T) {
+	buf := new(bytes.Buffer)
+	c := CreateCapybaraInstance()
+	c.Logger = NewLogger(LoggerConfig{Output: buf})
+	c.Use(RequestID(RequestIDConfig{}))
+	c.GET("/", func(ctx Context) {
+		ctx.Logger().Info("handled")
+	})
+
+	testCases := []struct {
+		inbound string
+		keep    bool
+	}{
+		{"req-1", true},
+		{"", false},
+		{"bad id\nforged=1", false},
+		{strings.Repeat("a", 129), false},
+	}
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	for _, tc := range testCases {
+		buf.Reset()
+		r := httptest.NewRequest("GET", "/", nil)
+		if tc.inbound != "" {
+			r.Header.Set(HEADER_X_REQUEST_ID, tc.inbound)
+		}
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, r)
+		id := w.Header().Get(HEADER_X_REQUEST_ID)
+		if tc.keep && id != tc.inbound || !tc.keep && !uuid.MatchString(id) {
+			t.Errorf("请求 ID 异常: %q -> %q", tc.inbound, id)
+		}
+		if !strings.Contains(buf.String(), "request_id="+id) {
+			t.Errorf("日志中没有请求 ID: %s", buf.String())
+		}
+	}
+}
+
+// 测试 HTTP 客户端传递请求 ID
+func TestRequestIDPropagation(t *testing.T) {
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get(HEADER_X_REQUEST_ID)))
+	}))
+	defer downstream.Close()
+
+	client := NewHTTPClient()
+	c := CreateCapybaraInstance()
+	c.Use(RequestID(RequestIDConfig{Generator: func() string { return "generated" }}))
+	c.GET("/", func(ctx Context) {
+		req, _ := http.NewRequestWithContext(ctx.Request().Context(), "GET", downstream.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("请求下游失败: %v", err)
+			return
+		}
+		defer resp.Body.Close()
+		ctx.Stream(200, TEXT_PLAIN, resp.Body)
+	})
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Body.String() != "generated" {
+		t.Errorf("请求 ID 没有传递给下游: %q", w.Body.String())
+	}
+}
@@ -0,0 +1,129 @@
+package capybara
+
+import "strings"
+
+// 资源控制器可以实现下面任意几个接口，没有实现的动作不会注册路由
+//
+//	Index   GET    /users
+//	Create  POST   /users
+//	Show    GET    /users/:id
+//	Update  PUT    /users/:id
+//	Patch   PATCH  /users/:id
+//	Destroy DELETE /users/:id
+type (
+	ResourceIndexer interface {
+		Index(c Context)
+	}
+	ResourceCreator interface {
+		Create(c Context)
+	}
+	ResourceShower interface {
+		Show(c Context)
+	}
+	ResourceUpdater interface {
+		Update(c Context)
+	}
+	ResourcePatcher interface {
+		Patch(c Context)
+	}
+	ResourceDestroyer interface {
+		Destroy(c Context)
+	}
+	// 为单个动作指定中间件，key 为动作名，例如 "Create"
+	ResourceMiddlewares interface {
+		ActionMiddlewares() map[string][]Middlewares
+	}
+)
+
+// 注册好的资源，可以继续注册嵌套资源
+type Resource struct {
+	router *Router
+	path   string
+	param  string
+}
+
+// 注册一个 RESTful 资源，middlewares 作用于该资源的所有动作
+//
+//	users := r.Resource("/users", &UserController{}).Param("userId")
+//	users.Resource("/posts", &PostController{}) // /users/:userId/posts
+func (r *Router) Resource(path string, controller interface{}, middlewares ...Middlewares) *Resource {
+	var actionMiddlewares map[string][]Middlewares
+	if m, ok := controller.(ResourceMiddlewares); ok {
+		actionMiddlewares = m.ActionMiddlewares()
+	}
+	itemPath := joinPath(path, "/:id")
+	add := func(action string, method string, path string, handler HandlerFunc) {
+		mws := make([]Middlewares, 0, len(middlewares)+len(actionMiddlewares[action]))
+		mws = append(mws, middlewares...)
+		mws = append(mws, actionMiddlewares[action]...)
+		r.Add(method, path, handler, mws...)
+	}
+
+	if ctrl, ok := controller.(ResourceIndexer); ok {
+		add("Index", "GET", path, ctrl.Index)
+	}
+	if ctrl, ok := controller.(ResourceCreator); ok {
+		add("Create", "POST", path, ctrl.Create)
+	}
+	if ctrl, ok := controller.(ResourceShower); ok {
+		add("Show", "GET", itemPath, ctrl.Show)
+	}
+	if ctrl, ok := controller.(ResourceUpdater); ok {
+		add("Update", "PUT", itemPath, ctrl.Update)
+	}
+	if ctrl, ok := controller.(ResourcePatcher); ok {
+		add("Patch", "PATCH", itemPath, ctrl.Patch)
+	}
+	if ctrl, ok := controller.(ResourceDestroyer); ok {
+		add("Destroy", "DELETE", itemPath, ctrl.Destroy)
+	}
+	return &Resource{router: r, path: path, param: resourceParam(path)}
+}
+
+// 注册嵌套资源，父资源的 ID 作为路由参数
+//
+// 参数名默认为父资源路径最后一段的单数形式加 Id，例如 /users 下的 /posts 注册为 /users/:userId/posts；
+// 单数形式只是去掉英文复数的词尾，不规则的复数需要使用 Param 指定
+func (res *Resource) Resource(path string, controller interface{}, middlewares ...Middlewares) *Resource {
+	return res.router.Resource(joinPath(joinPath(res.path, "/:"+res.param), path), controller, middlewares...)
+}
+
+// 指定嵌套资源使用的父资源参数名，需要在注册嵌套资源之前调用
+//
+//	r.Resource("/status", ctrl).Param("statusId")
+func (res *Resource) Param(name string) *Resource {
+	res.param = name
+	return res
+}
+
+// 嵌套资源默认使用的父资源参数名
+//
+//	/users => userId, /addresses => addressId, /categories => categoryId, /v1/status => statusId
+func resourceParam(path string) string {
+	segments := splitPath(path)
+	if len(segments) == 0 {
+		return "parentId"
+	}
+	return singular(segments[len(segments)-1]) + "Id"
+}
+
+// 去掉英文复数的词尾：ies => y，s/x/z/ch/sh 之后的 es 以及其余的 s；以 ss、us、is 结尾的视为单数
+func singular(word string) string {
+	switch {
+	case len(word) > 3 && strings.HasSuffix(word, "ies"):
+		return word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "sses"), strings.HasSuffix(word, "xes"), strings.HasSuffix(word, "zes"),
+		strings.HasSuffix(word, "ches"), strings.HasSuffix(word, "shes"):
+		return word[:len(word)-2]
+	case strings.HasSuffix(word, "ss"), strings.HasSuffix(word, "us"), strings.HasSuffix(word, "is"):
+		return word
+	case len(word) > 1 && strings.HasSuffix(word, "s"):
+		return word[:len(word)-1]
+	}
+	return word
+}
+
+// 在实例上注册 RESTful 资源
+func (c *capybara) Resource(path string, controller interface{}, middlewares ...Middlewares) *Resource {
+	return c.router.Resource(path, controller, middlewares...)
+}
@@ -0,0 +1,102 @@
+package capybara
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+type userController struct{}
+
+func (userController) Index(c Context)   { c.String(200, "index") }
+func (userController) Create(c Context)  { c.String(201, "create") }
+func (userController) Show(c Context)    { c.String(200, "show "+c.Param("id")) }
+func (userController) Update(c Context)  { c.String(200, "update "+c.Param("id")) }
+func (userController) Destroy(c Context) { c.String(200, "destroy "+c.Param("id")) }
+
+func (userController) ActionMiddlewares() map[string][]Middlewares {
+	return map[string][]Middlewares{
+		"Destroy": {func(next HandlerFunc) HandlerFunc {
+			return func(c Context) {
+				c.String(403, "forbidden")
+			}
+		}},
+	}
+}
+
+type postController struct{}
+
+func (postController) Index(c Context) { c.String(200, "posts of "+c.Param("userId")) }
+func (postController) Show(c Context)  { c.String(200, "post "+c.Param("id")+" of "+c.Param("userId")) }
+
+// 测试资源路由与嵌套资源
+func TestResource(t *testing.T) {
+	c := CreateCapybaraInstance()
+	users := c.Group("/api").Resource("/users", userController{})
+	users.Resource("/posts", postController{})
+	// 没有前导斜杠的路径同样作为子路径
+	users.Resource("comments", postController{})
+
+	testCases := []struct {
+		method   string
+		path     string
+		code     int
+		expected string
+	}{
+		{"GET", "/api/users", 200, "index"},
+		{"POST", "/api/users", 201, "create"},
+		{"GET", "/api/users/7", 200, "show 7"},
+		{"PUT", "/api/users/7", 200, "update 7"},
+		{"DELETE", "/api/users/7", 403, "forbidden"},
+		{"GET", "/api/users/7/posts", 200, "posts of 7"},
+		{"GET", "/api/users/7/posts/9", 200, "post 9 of 7"},
+		{"GET", "/api/users/7/comments/9", 200, "post 9 of 7"},
+	}
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.code || w.Body.String() != tc.expected {
+			t.Errorf("资源路由错误: %s %s 期望 %d %s 得到 %d %s", tc.method, tc.path, tc.code, tc.expected, w.Code, w.Body.String())
+		}
+	}
+
+	// 没有实现 Patch 的控制器不注册 PATCH
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("PATCH", "/api/users/7", nil))
+	if w.Code == 200 {
+		t.Error("未实现的动作被注册")
+	}
+}
+
+// 测试嵌套资源的参数名
+func TestResourceParam(t *testing.T) {
+	testCases := map[string]string{
+		"/users":      "userId",
+		"/status":     "statusId",
+		"/addresses":  "addressId",
+		"/categories": "categoryId",
+		"/boxes":      "boxId",
+		"/branches":   "branchId",
+		"/v1/data":    "dataId",
+		"/":           "parentId",
+	}
+	for path, expected := range testCases {
+		if result := resourceParam(path); result != expected {
+			t.Errorf("参数名错误: 输入 %s 期望 %s 得到 %s", path, expected, result)
+		}
+	}
+
+	c := CreateCapybaraInstance()
+	c.Resource("/status", userController{}).Resource("/posts", postController{})
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("GET", "/status/7/posts", nil))
+	if w.Code != 200 || c.router.tree.childrens["status"].childrens[":statusId"] == nil {
+		t.Errorf("默认参数名的路由异常: %d %s", w.Code, w.Body.String())
+	}
+	// 不规则的复数使用 Param 指定
+	c.Resource("/people", userController{}).Param("userId").Resource("/posts", postController{})
+	w = httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("GET", "/people/7/posts", nil))
+	if w.Body.String() != "posts of 7" {
+		t.Errorf("指定参数名的路由异常: %d %s", w.Code, w.Body.String())
+	}
+}
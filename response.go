@@ -0,0 +1,59 @@
+package capybara
+
+import (
+	"net/http"
+)
+
+// 对 http.ResponseWriter 的包装，记录状态码与响应大小
+type Response struct {
+	Writer http.ResponseWriter
+	// 响应状态码，未写入时为 200
+	Status int
+	// 已写入的响应体字节数
+	Size int64
+	// 是否已经写入响应头
+	Committed bool
+}
+
+func (r *Response) reset(w http.ResponseWriter) {
+	r.Writer = w
+	r.Status = http.StatusOK
+	r.Size = 0
+	r.Committed = false
+}
+
+func (r *Response) Header() http.Header {
+	return r.Writer.Header()
+}
+
+// 写入状态码，重复写入会被忽略
+func (r *Response) WriteHeader(code int) {
+	if r.Committed {
+		return
+	}
+	r.Status = code
+	r.Committed = true
+	r.Writer.WriteHeader(code)
+}
+
+func (r *Response) Write(b []byte) (int, error) {
+	if !r.Committed {
+		r.WriteHeader(http.StatusOK)
+	}
+	n, err := r.Writer.Write(b)
+	r.Size += int64(n)
+	return n, err
+}
+
+// 实现 http.Flusher
+func (r *Response) Flush() {
+	if !r.Committed {
+		r.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(r.Writer).Flush()
+}
+
+// 供 http.ResponseController 取得底层的 ResponseWriter，用于 Hijack 等操作
+func (r *Response) Unwrap() http.ResponseWriter {
+	return r.Writer
+}
@@ -0,0 +1,296 @@
+package capybara
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 备份文件名中的时间格式
+const rotateTimeFormat = "20060102T150405.000"
+
+var ErrFileClosed = errors.New("file already closed")
+
+// 日志文件切割的配置
+type RotateConfig struct {
+	// 日志文件的路径，备份文件保存在同一目录，例如 access-20241019T150405.000.log.gz
+	Filename string
+	// 单个文件的最大字节数，为 0 时不按大小切割
+	MaxSize int64
+	// 按时间切割的间隔，例如 24 * time.Hour 表示每天（UTC）切割一次，为 0 时不按时间切割
+	Interval time.Duration
+	// 保留的备份数量，为 0 时全部保留
+	MaxBackups int
+	// 使用 gzip 压缩备份，压缩失败时保留未压缩的备份
+	Compress bool
+	// 后台压缩或清理备份出错时调用
+	OnError func(err error)
+}
+
+// 按大小和时间切割的日志文件，可以作为 LoggerConfig、AccessLogConfig 的输出
+//
+//	file, err := capybara.NewRotatingFile(capybara.RotateConfig{
+//		Filename:   "logs/access.log",
+//		MaxSize:    100 << 20,
+//		MaxBackups: 7,
+//		Compress:   true,
+//	})
+type RotatingFile struct {
+	config RotateConfig
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	// 下一次按时间切割的时刻
+	next time.Time
+	// 上一个备份的时间戳与序号，保证同一毫秒内的序号递增
+	lastStamp string
+	lastSeq   int
+	// 压缩与清理在后台进行，同一时刻只有一个
+	cleanup sync.Mutex
+	wg      sync.WaitGroup
+	closed  bool
+}
+
+// 打开日志文件，已存在时继续追加
+func NewRotatingFile(config RotateConfig) (*RotatingFile, error) {
+	f := &RotatingFile{config: config}
+	if err := os.MkdirAll(filepath.Dir(config.Filename), 0755); err != nil {
+		return nil, err
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.config.Filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	if f.config.Interval > 0 {
+		f.next = time.Now().Truncate(f.config.Interval).Add(f.config.Interval)
+	}
+	return nil
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, ErrFileClosed
+	}
+	due := f.config.Interval > 0 && !time.Now().Before(f.next)
+	full := f.config.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.config.MaxSize
+	if due || full {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// 立即切割，例如收到 SIGHUP 时
+func (f *RotatingFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrFileClosed
+	}
+	return f.rotate()
+}
+
+// 切割失败时重新打开原来的文件，之后的写入继续追加到原文件
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	backup := f.backupName(time.Now())
+	if err == nil {
+		err = os.Rename(f.config.Filename, backup)
+	}
+	if err != nil {
+		return errors.Join(err, f.open())
+	}
+	if err := f.open(); err != nil {
+		// 无法创建新文件时把备份移回原来的位置
+		if renameErr := os.Rename(backup, f.config.Filename); renameErr != nil {
+			return errors.Join(err, renameErr)
+		}
+		return errors.Join(err, f.open())
+	}
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.cleanup.Lock()
+		defer f.cleanup.Unlock()
+		if f.config.Compress {
+			if err := compressFile(backup); err != nil {
+				f.reportError(err)
+			}
+		}
+		f.removeBackups()
+	}()
+	return nil
+}
+
+// 压缩成功后删除原文件
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
+
+// 备份文件名，同一毫秒内已有备份时追加序号，例如 access-20241019T150405.000-1.log
+func (f *RotatingFile) backupName(t time.Time) string {
+	ext := filepath.Ext(f.config.Filename)
+	stamp := t.Format(rotateTimeFormat)
+	base := strings.TrimSuffix(f.config.Filename, ext) + "-" + stamp
+	seq := 0
+	if stamp == f.lastStamp {
+		// 之前的备份可能已经被清理，不能复用它的名字
+		seq = f.lastSeq + 1
+	}
+	for backupExists(backupFilename(base, seq, ext)) {
+		seq++
+	}
+	f.lastStamp, f.lastSeq = stamp, seq
+	return backupFilename(base, seq, ext)
+}
+
+func backupFilename(base string, seq int, ext string) string {
+	if seq == 0 {
+		return base + ext
+	}
+	return base + "-" + strconv.Itoa(seq) + ext
+}
+
+// 备份或者它压缩后的文件已经存在
+func backupExists(name string) bool {
+	for _, n := range []string{name, name + ".gz"} {
+		if _, err := os.Lstat(n); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// 解析备份文件名中的时间戳与序号
+func parseBackupName(name, prefix, ext string) (string, int, bool) {
+	name = strings.TrimSuffix(name, ".gz")
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+		return "", 0, false
+	}
+	stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+	seq := 0
+	if i := strings.IndexByte(stamp, '-'); i >= 0 {
+		n, err := strconv.Atoi(stamp[i+1:])
+		if err != nil || n <= 0 {
+			return "", 0, false
+		}
+		stamp, seq = stamp[:i], n
+	}
+	if _, err := time.Parse(rotateTimeFormat, stamp); err != nil {
+		return "", 0, false
+	}
+	return stamp, seq, true
+}
+
+// 备份文件，按时间从旧到新排列
+func (f *RotatingFile) backups() []string {
+	ext := filepath.Ext(f.config.Filename)
+	prefix := filepath.Base(strings.TrimSuffix(f.config.Filename, ext)) + "-"
+	entries, err := os.ReadDir(filepath.Dir(f.config.Filename))
+	if err != nil {
+		return nil
+	}
+	type backup struct {
+		name  string
+		stamp string
+		seq   int
+	}
+	backups := make([]backup, 0)
+	for _, entry := range entries {
+		if stamp, seq, ok := parseBackupName(entry.Name(), prefix, ext); ok {
+			backups = append(backups, backup{entry.Name(), stamp, seq})
+		}
+	}
+	// 时间戳的格式可以直接按字符串比较，同一时间戳按序号排列
+	sort.Slice(backups, func(i, j int) bool {
+		if backups[i].stamp != backups[j].stamp {
+			return backups[i].stamp < backups[j].stamp
+		}
+		return backups[i].seq < backups[j].seq
+	})
+	names := make([]string, len(backups))
+	for i, b := range backups {
+		names[i] = b.name
+	}
+	return names
+}
+
+func (f *RotatingFile) removeBackups() {
+	if f.config.MaxBackups <= 0 {
+		return
+	}
+	backups := f.backups()
+	dir := filepath.Dir(f.config.Filename)
+	for len(backups) > f.config.MaxBackups {
+		if err := os.Remove(filepath.Join(dir, backups[0])); err != nil {
+			f.reportError(err)
+		}
+		backups = backups[1:]
+	}
+}
+
+func (f *RotatingFile) reportError(err error) {
+	if f.config.OnError != nil {
+		f.config.OnError(err)
+	}
+}
+
+// 关闭文件，等待后台的压缩与清理结束
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	err := f.file.Close()
+	f.mu.Unlock()
+	f.wg.Wait()
+	return err
+}
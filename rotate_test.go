@@ -0,0 +1,177 @@
+package capybara
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 测试按大小切割、压缩与保留的备份数量
+func TestRotatingFileSize(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "access.log")
+	f, err := NewRotatingFile(RotateConfig{Filename: name, MaxSize: 10, MaxBackups: 2, Compress: true})
+	if err != nil {
+		t.Fatalf("打开文件失败: %v", err)
+	}
+	for _, line := range []string{"line-1\n", "line-2\n", "line-3\n", "line-4\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("写入失败: %v", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("关闭失败: %v", err)
+	}
+
+	if b, _ := os.ReadFile(name); string(b) != "line-4\n" {
+		t.Errorf("当前文件内容异常: %q", b)
+	}
+	backups := f.backups()
+	if len(backups) != 2 || !strings.HasSuffix(backups[0], ".gz") || !strings.HasSuffix(backups[1], ".gz") {
+		t.Fatalf("备份数量异常: %v", backups)
+	}
+	gz, err := os.Open(filepath.Join(dir, backups[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gz.Close()
+	r, err := gzip.NewReader(gz)
+	if err != nil {
+		t.Fatalf("备份不是 gzip 格式: %v", err)
+	}
+	if b, _ := io.ReadAll(r); string(b) != "line-3\n" {
+		t.Errorf("最新的备份内容异常: %q", b)
+	}
+	if _, err := f.Write([]byte("x")); err != ErrFileClosed {
+		t.Errorf("关闭后仍可写入: %v", err)
+	}
+}
+
+// 测试按时间切割
+func TestRotatingFileInterval(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	f, err := NewRotatingFile(RotateConfig{Filename: name, Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("old\n"))
+	// 假装已经到了下一个切割时刻
+	f.next = time.Now().Add(-time.Second)
+	f.Write([]byte("new\n"))
+	f.Close()
+
+	backups, _ := filepath.Glob(filepath.Join(dir, "app-*.log"))
+	if len(backups) != 1 {
+		t.Fatalf("备份数量异常: %v", backups)
+	}
+	if b, _ := os.ReadFile(backups[0]); string(b) != "old\n" {
+		t.Errorf("备份内容异常: %q", b)
+	}
+	if b, _ := os.ReadFile(name); !strings.HasPrefix(string(b), "new") {
+		t.Errorf("当前文件内容异常: %q", b)
+	}
+}
+
+// 测试同一毫秒内多次切割时备份不会互相覆盖
+func TestRotatingFileSameStamp(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	f, err := NewRotatingFile(RotateConfig{Filename: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, line := range []string{"a", "b", "c"} {
+		f.Write([]byte(line))
+		// 固定时间戳模拟同一毫秒内的切割
+		if err := os.Rename(name, f.backupName(now)); err != nil {
+			t.Fatal(err)
+		}
+		f.file.Close()
+		if err := f.open(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	backups := f.backups()
+	if len(backups) != 3 {
+		t.Fatalf("备份被覆盖: %v", backups)
+	}
+	var content string
+	for _, backup := range backups {
+		b, _ := os.ReadFile(filepath.Join(dir, backup))
+		content += string(b)
+	}
+	if content != "abc" {
+		t.Errorf("备份的顺序或内容异常: %v %q", backups, content)
+	}
+}
+
+// 测试切割失败后继续写入原文件
+func TestRotatingFileRotateFailure(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	f, err := NewRotatingFile(RotateConfig{Filename: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write([]byte("a"))
+	// 文件被外部删除，重命名失败
+	os.Remove(name)
+	if err := f.Rotate(); err == nil {
+		t.Fatal("切割失败时没有返回错误")
+	}
+	if _, err := f.Write([]byte("b")); err != nil {
+		t.Fatalf("切割失败后无法继续写入: %v", err)
+	}
+	if b, _ := os.ReadFile(name); string(b) != "b" {
+		t.Errorf("当前文件内容异常: %q", b)
+	}
+}
+
+// 测试压缩失败时保留未压缩的备份
+func TestCompressFileError(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "app-20241019T150405.000.log")
+	os.WriteFile(name, []byte("a"), 0644)
+	// .gz 被目录占用，压缩失败
+	if err := os.Mkdir(name+".gz", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := compressFile(name); err == nil {
+		t.Error("压缩失败时没有返回错误")
+	}
+	if b, _ := os.ReadFile(name); string(b) != "a" {
+		t.Errorf("压缩失败后未压缩的备份被删除: %q", b)
+	}
+}
+
+// 测试后台清理出错时调用 OnError
+func TestRotatingFileOnError(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	// 最旧的备份是非空目录，无法删除
+	old := filepath.Join(dir, "app-20000101T000000.000.log")
+	if err := os.MkdirAll(filepath.Join(old, "keep"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var errs []error
+	f, err := NewRotatingFile(RotateConfig{Filename: name, MaxBackups: 1, OnError: func(err error) { errs = append(errs, err) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("a"))
+	if err := f.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if len(errs) != 1 {
+		t.Errorf("清理失败没有报告: %v", errs)
+	}
+}
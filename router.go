@@ -95,6 +95,15 @@ func (r *Router) TRACE(path string, handler HandlerFunc, middlewares ...Middlewa
 	r.c.TRACE(fullPath, handler, middlewares...)
 }
 
+// 按请求方法注册路由
+func (r *Router) Add(method string, path string, handler HandlerFunc, middlewares ...Middlewares) {
+	fullPath := joinPath(r.prefix, path)
+	if len(r.middlewares) != 0 {
+		handler = applyMiddlewares(handler, r.middlewares...)
+	}
+	r.c.Add(method, fullPath, handler, middlewares...)
+}
+
 func (r *Router) Use(middlewares ...Middlewares) *Router {
 	r.middlewares = append(r.middlewares, middlewares...)
 	return r
@@ -0,0 +1,79 @@
+package capybara
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+var ErrTrailingData = errors.New("unexpected data after top-level JSON value")
+
+// JSON 序列化接口，可以替换成更快的实现或者统一开启严格解码
+type JSONSerializer interface {
+	// 将 data 编码后写入 w，indent 不为空时使用该缩进
+	Serialize(w io.Writer, data interface{}, indent string) error
+	// 从 r 中解码到 data
+	Deserialize(r io.Reader, data interface{}) error
+}
+
+// 基于 encoding/json 的默认实现
+type DefaultJSONSerializer struct {
+	// 请求体中出现结构体没有的字段时报错
+	DisallowUnknownFields bool
+	// 数字解码为 json.Number 而不是 float64
+	UseNumber bool
+	// 不转义 <、>、& 等 HTML 字符
+	DisableHTMLEscape bool
+	// 默认的缩进，为空时输出紧凑的 JSON
+	Indent string
+}
+
+func (s *DefaultJSONSerializer) Serialize(w io.Writer, data interface{}, indent string) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(!s.DisableHTMLEscape)
+	if indent == "" {
+		indent = s.Indent
+	}
+	if indent != "" {
+		encoder.SetIndent("", indent)
+	}
+	return encoder.Encode(data)
+}
+
+func (s *DefaultJSONSerializer) Deserialize(r io.Reader, data interface{}) error {
+	decoder := json.NewDecoder(r)
+	if s.DisallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+	if s.UseNumber {
+		decoder.UseNumber()
+	}
+	if err := decoder.Decode(data); err != nil {
+		return err
+	}
+	// 与 json.Unmarshal 一样，请求体中只能有一个 JSON 值
+	if err := decoder.Decode(&struct{}{}); err != io.EOF {
+		return ErrTrailingData
+	}
+	return nil
+}
+
+var defaultJSONSerializer JSONSerializer = &DefaultJSONSerializer{}
+
+// 返回实例上注册的 JSON 序列化器
+func (c *capybara) jsonSerializer() JSONSerializer {
+	if c == nil || c.JSONSerializer == nil {
+		return defaultJSONSerializer
+	}
+	return c.JSONSerializer
+}
+
+// 使用实例的序列化器编码到内存中，编码失败时不会写出半个响应
+func (c *capybara) marshalJSON(data interface{}, indent string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := c.jsonSerializer().Serialize(buf, data, indent); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,161 @@
+package capybara
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TEXT_EVENT_STREAM = "text/event-stream"
+	CACHE_CONTROL     = "Cache-Control"
+	LAST_EVENT_ID     = "Last-Event-ID"
+)
+
+var ErrInvalidEventField = errors.New("sse: id and event must not contain line breaks")
+
+// 一条 Server-Sent Event
+type Event struct {
+	ID    string
+	Event string
+	// 客户端断线重连的等待时间，0 表示不发送
+	Retry time.Duration
+	// 多行数据会拆成多个 data 字段
+	Data string
+}
+
+// Server-Sent Events 的写入器，可以在多个 goroutine 中并发使用
+type SSEWriter struct {
+	mu          sync.Mutex
+	w           http.ResponseWriter
+	rc          *http.ResponseController
+	r           *http.Request
+	lastEventID string
+}
+
+// 开始一个 Server-Sent Events 响应
+//
+// 路由函数需要一直阻塞到 Done() 关闭，返回后连接即结束：
+//
+//	sse, err := c.SSE()
+//	if err != nil {
+//		return
+//	}
+//	stop := sse.Heartbeat(15 * time.Second)
+//	defer stop()
+//	for {
+//		select {
+//		case <-sse.Done():
+//			return
+//		case msg := <-updates:
+//			sse.Send(Event{Event: "update", Data: msg})
+//		}
+//	}
+func (c *context) SSE() (*SSEWriter, error) {
+	s := &SSEWriter{
+		w:           c.w,
+		rc:          http.NewResponseController(c.w),
+		r:           c.r,
+		lastEventID: c.r.Header.Get(LAST_EVENT_ID),
+	}
+	header := c.w.Header()
+	header.Set(CONTENT_TYPE, TEXT_EVENT_STREAM)
+	header.Set(CACHE_CONTROL, "no-cache")
+	header.Set("Connection", "keep-alive")
+	// 关闭 nginx 的响应缓冲
+	header.Set("X-Accel-Buffering", "no")
+	c.w.WriteHeader(http.StatusOK)
+	if err := s.rc.Flush(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// 客户端断线重连时带上的最后一个事件 ID
+func (s *SSEWriter) LastEventID() string {
+	return s.lastEventID
+}
+
+// 客户端断开连接时关闭
+func (s *SSEWriter) Done() <-chan struct{} {
+	return s.r.Context().Done()
+}
+
+// 发送一个事件并立即刷新
+func (s *SSEWriter) Send(e Event) error {
+	if strings.ContainsAny(e.ID, "\r\n\x00") || strings.ContainsAny(e.Event, "\r\n") {
+		return ErrInvalidEventField
+	}
+	var b strings.Builder
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + e.Event + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	data := strings.ReplaceAll(e.Data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// 发送一行注释，客户端会忽略它，可以用来保持连接
+func (s *SSEWriter) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		b.WriteString(": " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// 每隔 interval 发送一次心跳注释，直到客户端断开或调用返回的 stop
+//
+// stop 会等待心跳的 goroutine 退出，路由函数返回前必须调用
+func (s *SSEWriter) Heartbeat(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	var once sync.Once
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-s.Done():
+				return
+			case <-ticker.C:
+				if s.Comment("heartbeat") != nil {
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		once.Do(func() { close(done) })
+		<-exited
+	}
+}
+
+func (s *SSEWriter) write(msg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.r.Context().Err(); err != nil {
+		return err
+	}
+	if _, err := s.w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
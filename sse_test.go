@@ -0,0 +1,45 @@
+package capybara
+
+import (
+	gocontext "context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// 测试事件编码
+func TestSSESend(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(LAST_EVENT_ID, "41")
+	c, w := newTestContext(CreateCapybaraInstance(), r)
+	sse, err := c.SSE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sse.LastEventID() != "41" {
+		t.Error("Last-Event-ID 读取失败")
+	}
+	sse.Send(Event{ID: "42", Event: "update", Retry: 3 * time.Second, Data: "line1\r\nline2"})
+	expected := "id: 42\nevent: update\nretry: 3000\ndata: line1\ndata: line2\n\n"
+	if w.Body.String() != expected || w.Header().Get(CONTENT_TYPE) != TEXT_EVENT_STREAM || !w.Flushed {
+		t.Errorf("事件编码异常: %q", w.Body.String())
+	}
+	if err := sse.Send(Event{ID: "1\n2"}); err != ErrInvalidEventField {
+		t.Error("非法的事件 ID 未被拒绝")
+	}
+}
+
+// 测试客户端断开后停止发送
+func TestSSEClientGone(t *testing.T) {
+	cancelCtx, cancel := gocontext.WithCancel(gocontext.Background())
+	r := httptest.NewRequest("GET", "/", nil).WithContext(cancelCtx)
+	c, _ := newTestContext(CreateCapybaraInstance(), r)
+	sse, _ := c.SSE()
+	stop := sse.Heartbeat(time.Millisecond)
+	cancel()
+	<-sse.Done()
+	stop()
+	if err := sse.Send(Event{Data: "x"}); err == nil {
+		t.Error("客户端断开后仍可发送")
+	}
+}
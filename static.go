@@ -0,0 +1,175 @@
+package capybara
+
+import (
+	"errors"
+	"html"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	ACCEPT_ENCODING  = "Accept-Encoding"
+	CONTENT_ENCODING = "Content-Encoding"
+	VARY             = "Vary"
+)
+
+// 静态文件服务的配置
+type StaticConfig struct {
+	// 本地目录，Filesystem 为空时使用
+	Root string
+	// 文件系统，可以是 embed.FS
+	Filesystem fs.FS
+	// 目录的首页文件，默认为 index.html
+	Index string
+	// 目录中没有首页时是否列出目录内容
+	Browse bool
+	// HTML5 history 模式：文件不存在时返回根目录的首页，用于单页应用
+	HTML5 bool
+	// 客户端支持时，优先发送同目录下预压缩的 .br / .gz 文件
+	Precompressed bool
+}
+
+// 预压缩文件的后缀，按优先级排列
+var precompressedEncodings = []struct {
+	encoding string
+	ext      string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// 挂载本地目录
+//
+//	c.Static("/static", "./public")
+func (c *capybara) Static(prefix, root string) {
+	c.StaticWithConfig(prefix, StaticConfig{Root: root, Precompressed: true})
+}
+
+// 挂载 fs.FS，可以配合 embed.FS 使用
+//
+//	//go:embed dist
+//	var dist embed.FS
+//	sub, _ := fs.Sub(dist, "dist")
+//	c.StaticFS("/", sub)
+func (c *capybara) StaticFS(prefix string, fsys fs.FS) {
+	c.StaticWithConfig(prefix, StaticConfig{Filesystem: fsys, Precompressed: true})
+}
+
+// 按照配置挂载静态文件，基于 *filepath 通配符路由实现
+func (c *capybara) StaticWithConfig(prefix string, config StaticConfig) {
+	if config.Filesystem == nil {
+		if config.Root == "" {
+			config.Root = "."
+		}
+		config.Filesystem = os.DirFS(config.Root)
+	}
+	if config.Index == "" {
+		config.Index = INDEX_HTML
+	}
+	handler := func(ctx Context) {
+		if err := config.serve(ctx.(*context), ctx.Param("filepath")); err != nil {
+			ctx.Error(err)
+		}
+	}
+	c.GET(prefix, handler)
+	c.GET(joinPath(prefix, "/*filepath"), handler)
+}
+
+func (config StaticConfig) serve(c *context, name string) error {
+	// path.Clean 会消去所有的 ..，保证不会跳出根目录
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) || strings.ContainsAny(name, "\\\x00") {
+		return c.fileError(fs.ErrInvalid)
+	}
+
+	info, err := fs.Stat(config.Filesystem, name)
+	if err != nil {
+		if config.HTML5 && errors.Is(err, fs.ErrNotExist) {
+			return config.serveFile(c, config.Index)
+		}
+		return c.fileError(err)
+	}
+	if !info.IsDir() {
+		return config.serveFile(c, name)
+	}
+
+	// 目录必须以 / 结尾，否则页面中的相对路径会出错
+	//
+	// 使用相对地址重定向，//evil.com/.. 这样的路径不会变成指向其他主机的地址
+	if !strings.HasSuffix(c.r.URL.Path, "/") {
+		u := url.URL{Path: path.Base(c.r.URL.Path) + "/", RawQuery: c.r.URL.RawQuery}
+		return c.Redirect(http.StatusMovedPermanently, u.String())
+	}
+	index := path.Join(name, config.Index)
+	if _, err := fs.Stat(config.Filesystem, index); err == nil {
+		return config.serveFile(c, index)
+	}
+	if config.Browse {
+		return config.listDir(c, name)
+	}
+	if config.HTML5 {
+		return config.serveFile(c, config.Index)
+	}
+	return c.fileError(fs.ErrNotExist)
+}
+
+// 发送单个文件，客户端支持时发送预压缩的版本
+func (config StaticConfig) serveFile(c *context, name string) error {
+	if config.Precompressed {
+		c.w.Header().Add(VARY, ACCEPT_ENCODING)
+		accept := c.r.Header.Get(ACCEPT_ENCODING)
+		for _, p := range precompressedEncodings {
+			if !acceptsEncoding(accept, p.encoding) {
+				continue
+			}
+			f, err := config.Filesystem.Open(name + p.ext)
+			if err != nil {
+				continue
+			}
+			defer f.Close()
+			info, err := f.Stat()
+			if err != nil || info.IsDir() {
+				continue
+			}
+			contentType := mime.TypeByExtension(path.Ext(name))
+			if contentType == "" {
+				contentType = APPLICATION_OCTET_STREAM
+			}
+			c.w.Header().Set(CONTENT_TYPE, contentType)
+			c.w.Header().Set(CONTENT_ENCODING, p.encoding)
+			return c.serveContent(info, f)
+		}
+	}
+	return c.FileFS(config.Filesystem, name)
+}
+
+// 列出目录内容
+func (config StaticConfig) listDir(c *context, name string) error {
+	entries, err := fs.ReadDir(config.Filesystem, name)
+	if err != nil {
+		return c.fileError(err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var b strings.Builder
+	b.WriteString("<!doctype html>\n<meta name=\"viewport\" content=\"width=device-width\">\n<pre>\n")
+	for _, entry := range entries {
+		entryName := entry.Name()
+		if entry.IsDir() {
+			entryName += "/"
+		}
+		link := url.URL{Path: entryName}
+		b.WriteString("<a href=\"" + html.EscapeString(link.String()) + "\">" + html.EscapeString(entryName) + "</a>\n")
+	}
+	b.WriteString("</pre>\n")
+	return c.HTML(http.StatusOK, b.String())
+}
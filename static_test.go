@@ -0,0 +1,84 @@
+package capybara
+
+import (
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+var staticFS = fstest.MapFS{
+	"index.html":     {Data: []byte("<h1>app</h1>")},
+	"app.js":         {Data: []byte("console.log(1)")},
+	"app.js.gz":      {Data: []byte("gzipped")},
+	"files/a.txt":    {Data: []byte("a")},
+	"files/b<c>.txt": {Data: []byte("b")},
+}
+
+func serveStatic(c *capybara, method, target string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, r)
+	return w
+}
+
+// 测试静态文件与预压缩文件
+func TestStaticFS(t *testing.T) {
+	c := CreateCapybaraInstance()
+	c.StaticFS("/static", staticFS)
+
+	if w := serveStatic(c, "GET", "/static/app.js", nil); w.Body.String() != "console.log(1)" {
+		t.Errorf("静态文件发送失败: %s", w.Body.String())
+	}
+	w := serveStatic(c, "GET", "/static/app.js", map[string]string{ACCEPT_ENCODING: "br;q=0, gzip"})
+	if w.Body.String() != "gzipped" || w.Header().Get(CONTENT_ENCODING) != "gzip" || w.Header().Get(CONTENT_TYPE) != "text/javascript; charset=utf-8" {
+		t.Errorf("预压缩文件发送失败: %s %s", w.Header().Get(CONTENT_ENCODING), w.Header().Get(CONTENT_TYPE))
+	}
+	if w := serveStatic(c, "HEAD", "/static/", nil); w.Code != 200 || w.Body.Len() != 0 {
+		t.Errorf("HEAD 请求首页失败: %d", w.Code)
+	}
+	if w := serveStatic(c, "GET", "/static?v=1", nil); w.Code != 301 || w.Header().Get(LOCATION) != "static/?v=1" {
+		t.Errorf("目录重定向失败: %d %s", w.Code, w.Header().Get(LOCATION))
+	}
+}
+
+// 测试目录重定向不会跳转到其他主机
+func TestStaticOpenRedirect(t *testing.T) {
+	c := CreateCapybaraInstance()
+	c.StaticFS("/", staticFS)
+	w := serveStatic(c, "GET", "//evil.com/..", nil)
+	if w.Code != 301 || w.Header().Get(LOCATION) != "../" {
+		t.Errorf("目录重定向的地址异常: %d %s", w.Code, w.Header().Get(LOCATION))
+	}
+}
+
+// 测试路径穿越
+func TestStaticTraversal(t *testing.T) {
+	c := CreateCapybaraInstance()
+	c.StaticFS("/static", staticFS)
+	if w := serveStatic(c, "GET", "/static/../../files/a.txt", nil); w.Body.String() != "a" {
+		t.Errorf("路径未被限制在根目录内: %d", w.Code)
+	}
+	if w := serveStatic(c, "GET", "/static/..%5c..%5cindex.html", nil); w.Code != 404 {
+		t.Errorf("非法路径未被拒绝: %d", w.Code)
+	}
+}
+
+// 测试目录列表与单页应用回退
+func TestStaticBrowseAndHTML5(t *testing.T) {
+	c := CreateCapybaraInstance()
+	c.StaticWithConfig("/", StaticConfig{Filesystem: staticFS, Browse: true, HTML5: true})
+
+	w := serveStatic(c, "GET", "/files/", nil)
+	if w.Body.String() != "<!doctype html>\n<meta name=\"viewport\" content=\"width=device-width\">\n<pre>\n<a href=\"a.txt\">a.txt</a>\n<a href=\"b%3Cc%3E.txt\">b&lt;c&gt;.txt</a>\n</pre>\n" {
+		t.Errorf("目录列表异常: %s", w.Body.String())
+	}
+	if w := serveStatic(c, "GET", "/user/profile", nil); w.Body.String() != "<h1>app</h1>" {
+		t.Errorf("单页应用回退失败: %s", w.Body.String())
+	}
+	if w := serveStatic(c, "GET", "/", nil); w.Body.String() != "<h1>app</h1>" {
+		t.Errorf("根路径首页失败: %s", w.Body.String())
+	}
+}
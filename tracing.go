@@ -0,0 +1,522 @@
+package capybara
+
+import (
+	gocontext "context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// W3C Trace Context
+const (
+	HEADER_TRACEPARENT = "Traceparent"
+	HEADER_TRACESTATE  = "Tracestate"
+)
+
+// Span 的类型，取值与 OTLP 相同
+const (
+	SPAN_KIND_INTERNAL = 1
+	SPAN_KIND_SERVER   = 2
+	SPAN_KIND_CLIENT   = 3
+)
+
+// Span 的状态，取值与 OTLP 相同
+const (
+	SPAN_STATUS_UNSET = 0
+	SPAN_STATUS_OK    = 1
+	SPAN_STATUS_ERROR = 2
+)
+
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// 跨服务传递的 Span 信息
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	TraceFlags byte
+	TraceState string
+	// 是否来自上游服务
+	Remote bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+func (sc SpanContext) IsSampled() bool {
+	return sc.TraceFlags&0x01 != 0
+}
+
+// 生成 traceparent 请求头，格式为 version-trace_id-parent_id-flags
+func (sc SpanContext) Traceparent() string {
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + hex.EncodeToString([]byte{sc.TraceFlags})
+}
+
+// 解析 traceparent 请求头，更高的版本只读取前 4 个字段
+func ParseTraceparent(header string) (SpanContext, error) {
+	var sc SpanContext
+	header = strings.TrimSpace(header)
+	if len(header) < 55 || header[2] != '-' || header[35] != '-' || header[52] != '-' {
+		return sc, ErrInvalidTraceparent
+	}
+	version, ok := decodeLowerHex(header[:2])
+	if !ok || version[0] == 0xff || version[0] == 0 && len(header) != 55 || len(header) > 55 && header[55] != '-' {
+		return sc, ErrInvalidTraceparent
+	}
+	traceID, ok1 := decodeLowerHex(header[3:35])
+	spanID, ok2 := decodeLowerHex(header[36:52])
+	flags, ok3 := decodeLowerHex(header[53:55])
+	if !ok1 || !ok2 || !ok3 {
+		return sc, ErrInvalidTraceparent
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.TraceFlags = flags[0]
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	sc.Remote = true
+	return sc, nil
+}
+
+// traceparent 只允许小写的十六进制
+func decodeLowerHex(s string) ([]byte, bool) {
+	for i := 0; i < len(s); i++ {
+		if ch := s[i]; !(ch >= '0' && ch <= '9' || ch >= 'a' && ch <= 'f') {
+			return nil, false
+		}
+	}
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
+
+// 从请求头中读取上游的 SpanContext
+func extractSpanContext(header http.Header) (SpanContext, bool) {
+	sc, err := ParseTraceparent(header.Get(HEADER_TRACEPARENT))
+	if err != nil {
+		return SpanContext{}, false
+	}
+	// 多个 tracestate 请求头按顺序合并
+	sc.TraceState = strings.Join(header.Values(HEADER_TRACESTATE), ",")
+	return sc, true
+}
+
+// 把 SpanContext 写入请求头
+func injectSpanContext(header http.Header, sc SpanContext) {
+	header.Set(HEADER_TRACEPARENT, sc.Traceparent())
+	if sc.TraceState != "" {
+		header.Set(HEADER_TRACESTATE, sc.TraceState)
+	}
+}
+
+// Span 上的事件
+type SpanEvent struct {
+	Name       string
+	Time       time.Time
+	Attributes map[string]interface{}
+}
+
+// 一次操作的耗时与信息，nil 的 Span 可以安全调用所有方法
+type Span struct {
+	Name          string
+	Kind          int
+	Context       SpanContext
+	Parent        SpanID
+	StartTime     time.Time
+	EndTime       time.Time
+	Attributes    map[string]interface{}
+	Events        []SpanEvent
+	Status        int
+	StatusMessage string
+
+	tracer *Tracer
+	mu     sync.Mutex
+	ended  bool
+	// 结束时执行，Context.StartSpan 用来恢复父 Span
+	onEnd func()
+}
+
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.Context
+}
+
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]interface{})
+	}
+	s.Attributes[key] = value
+}
+
+// 记录一个事件，attributes 为交替出现的键值对
+func (s *Span) AddEvent(name string, attributes ...interface{}) {
+	if s == nil {
+		return
+	}
+	event := SpanEvent{Name: name, Time: time.Now(), Attributes: make(map[string]interface{})}
+	for i := 0; i+1 < len(attributes); i += 2 {
+		event.Attributes[fmt.Sprint(attributes[i])] = attributes[i+1]
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.Events = append(s.Events, event)
+	}
+}
+
+func (s *Span) SetStatus(code int, message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.Status, s.StatusMessage = code, message
+	}
+}
+
+// 记录错误并把状态设置为 SPAN_STATUS_ERROR
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.AddEvent("exception", "exception.message", err.Error(), "exception.type", fmt.Sprintf("%T", err))
+	s.SetStatus(SPAN_STATUS_ERROR, err.Error())
+}
+
+// 结束 Span，被采样的 Span 交给导出器；结束之后的修改与重复调用都会被忽略
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mu.Unlock()
+	if s.onEnd != nil {
+		s.onEnd()
+	}
+	if s.Context.IsSampled() {
+		s.tracer.enqueue(s)
+	}
+}
+
+// 导出已经结束的 Span
+type SpanExporter interface {
+	ExportSpans(ctx gocontext.Context, spans []*Span) error
+	Shutdown(ctx gocontext.Context) error
+}
+
+// Tracer 的配置
+type TracerConfig struct {
+	Exporter SpanExporter
+	// 没有上游 traceparent 时的采样率，取值 (0, 1)；为 0 时全部采样，有上游时沿用上游的决定
+	SampleRate float64
+	// 每批导出的 Span 数量，默认为 512
+	BatchSize int
+	// 定时导出的间隔，默认为 5 秒
+	FlushInterval time.Duration
+	// 等待导出的 Span 上限，超过时丢弃，默认为 2048
+	QueueSize int
+	// 导出失败时调用
+	OnError func(err error)
+}
+
+// 创建 Span 并在后台批量导出
+//
+//	tracer := capybara.NewTracer(capybara.TracerConfig{
+//		Exporter: capybara.NewOTLPExporter(capybara.OTLPConfig{ServiceName: "shop"}),
+//	})
+//	c.OnShutdown(func() { tracer.Shutdown(gocontext.Background()) })
+//	c.Use(capybara.Tracing(tracer))
+type Tracer struct {
+	config  TracerConfig
+	mu      sync.Mutex
+	queue   []*Span
+	flush   chan chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	stopped bool
+}
+
+func NewTracer(config TracerConfig) *Tracer {
+	if config.BatchSize <= 0 {
+		config.BatchSize = 512
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 5 * time.Second
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 2048
+	}
+	t := &Tracer{
+		config: config,
+		flush:  make(chan chan struct{}),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+var spanKey = NewKey[*Span]("span")
+
+// 从 context 中取得当前的 Span，没有时返回 nil
+func SpanFromContext(ctx gocontext.Context) *Span {
+	span, _ := ctx.Value(spanKey).(*Span)
+	return span
+}
+
+// 开始一个 Span，context 中已有 Span 时作为它的子 Span，返回的 context 以新的 Span 作为当前 Span
+//
+//	ctx, span := tracer.Start(ctx, "load user", capybara.SPAN_KIND_INTERNAL)
+//	defer span.End()
+func (t *Tracer) Start(ctx gocontext.Context, name string, kind int) (gocontext.Context, *Span) {
+	var parent SpanContext
+	if span := SpanFromContext(ctx); span != nil {
+		parent = span.Context
+	}
+	span := t.newSpan(name, kind, parent)
+	return gocontext.WithValue(ctx, spanKey, span), span
+}
+
+func (t *Tracer) newSpan(name string, kind int, parent SpanContext) *Span {
+	span := &Span{Name: name, Kind: kind, StartTime: time.Now(), tracer: t}
+	span.Context.SpanID = newSpanID()
+	if parent.IsValid() {
+		span.Context.TraceID = parent.TraceID
+		span.Context.TraceFlags = parent.TraceFlags
+		span.Context.TraceState = parent.TraceState
+		span.Parent = parent.SpanID
+		return span
+	}
+	for !span.Context.TraceID.IsValid() {
+		binary.BigEndian.PutUint64(span.Context.TraceID[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(span.Context.TraceID[8:], rand.Uint64())
+	}
+	if t.config.SampleRate <= 0 || rand.Float64() < t.config.SampleRate {
+		span.Context.TraceFlags = 0x01
+	}
+	return span
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		binary.BigEndian.PutUint64(id[:], rand.Uint64())
+	}
+	return id
+}
+
+func (t *Tracer) enqueue(span *Span) {
+	t.mu.Lock()
+	if t.stopped || len(t.queue) >= t.config.QueueSize {
+		t.mu.Unlock()
+		return
+	}
+	t.queue = append(t.queue, span)
+	full := len(t.queue) >= t.config.BatchSize
+	t.mu.Unlock()
+	if full {
+		// 后台正在导出时不必等待
+		select {
+		case t.flush <- nil:
+		default:
+		}
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.export()
+		case flushed := <-t.flush:
+			t.export()
+			if flushed != nil {
+				close(flushed)
+			}
+		case <-t.stop:
+			t.export()
+			return
+		}
+	}
+}
+
+func (t *Tracer) export() {
+	for {
+		t.mu.Lock()
+		n := min(len(t.queue), t.config.BatchSize)
+		batch := t.queue[:n:n]
+		t.queue = t.queue[n:]
+		t.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		if t.config.Exporter == nil {
+			continue
+		}
+		ctx, cancel := gocontext.WithTimeout(gocontext.Background(), 30*time.Second)
+		err := t.config.Exporter.ExportSpans(ctx, batch)
+		cancel()
+		if err != nil && t.config.OnError != nil {
+			t.config.OnError(err)
+		}
+	}
+}
+
+// 立即导出所有已经结束的 Span
+func (t *Tracer) ForceFlush(ctx gocontext.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case t.flush <- flushed:
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 导出剩余的 Span 并关闭导出器
+func (t *Tracer) Shutdown(ctx gocontext.Context) error {
+	t.mu.Lock()
+	if t.stopped {
+		t.mu.Unlock()
+		return nil
+	}
+	t.stopped = true
+	t.mu.Unlock()
+	close(t.stop)
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if t.config.Exporter != nil {
+		return t.config.Exporter.Shutdown(ctx)
+	}
+	return nil
+}
+
+// 为每个请求创建一个 SERVER Span，名字为 "方法 路由"，并读取上游的 traceparent
+//
+// 建议通过 c.Use 注册，Span 保存在请求的 context 中，NewHTTPClient 发出的请求会带上 traceparent
+func Tracing(tracer *Tracer) Middlewares {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx Context) {
+			r := ctx.Request()
+			parent, _ := extractSpanContext(r.Header)
+			route := ctx.Path()
+			name := r.Method
+			if route != "" {
+				name += " " + route
+			}
+			span := tracer.newSpan(name, SPAN_KIND_SERVER, parent)
+			span.SetAttribute("http.request.method", r.Method)
+			if route != "" {
+				span.SetAttribute("http.route", route)
+			}
+			span.SetAttribute("url.path", r.URL.Path)
+			span.SetAttribute("url.scheme", ctx.Scheme())
+			span.SetAttribute("client.address", ctx.RealIP())
+			if ua := r.UserAgent(); ua != "" {
+				span.SetAttribute("user_agent.original", ua)
+			}
+			ctx.SetContext(gocontext.WithValue(r.Context(), spanKey, span))
+			defer func() {
+				// 注册在 Recovery 里层时 panic 还没有被处理，按 500 记录后继续向上抛出
+				if err := recover(); err != nil {
+					span.SetAttribute("http.response.status_code", http.StatusInternalServerError)
+					span.SetStatus(SPAN_STATUS_ERROR, fmt.Sprint("panic: ", err))
+					span.End()
+					panic(err)
+				}
+				span.End()
+			}()
+
+			next(ctx)
+
+			status := ctx.Response().Status
+			span.SetAttribute("http.response.status_code", status)
+			// 服务端只把 5xx 记为错误
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(SPAN_STATUS_ERROR, strconv.Itoa(status)+" "+http.StatusText(status))
+			}
+		}
+	}
+}
+
+// 在当前请求的 Span 下开始一个子 Span，没有使用 Tracing 中间件时返回 nil，nil 的 Span 可以正常调用
+//
+// 子 Span 会成为请求 context 中的当前 Span，之后开始的 Span 与 NewHTTPClient 发出的请求都以它为父 Span；
+// End 之后恢复为原来的 Span，所以需要在同一个请求的处理过程中按开始的相反顺序结束
+//
+//	span := ctx.StartSpan("query orders")
+//	defer span.End()
+func (c *context) StartSpan(name string) *Span {
+	c.assertAlive()
+	parent := SpanFromContext(c.r.Context())
+	if parent == nil {
+		return nil
+	}
+	span := parent.tracer.newSpan(name, SPAN_KIND_INTERNAL, parent.Context)
+	c.SetContext(gocontext.WithValue(c.r.Context(), spanKey, span))
+	span.onEnd = func() {
+		// 请求已经结束或者当前 Span 已经不是它时不做修改
+		if c.released || c.r == nil || SpanFromContext(c.r.Context()) != span {
+			return
+		}
+		c.SetContext(gocontext.WithValue(c.r.Context(), spanKey, parent))
+	}
+	return span
+}
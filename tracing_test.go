@@ -0,0 +1,219 @@
+package capybara
+
+import (
+	gocontext "context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// 测试解析 traceparent
+func TestParseTraceparent(t *testing.T) {
+	testCases := []struct {
+		header string
+		valid  bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+	}
+	for _, tc := range testCases {
+		sc, err := ParseTraceparent(tc.header)
+		if (err == nil) != tc.valid {
+			t.Errorf("解析结果异常: %s %v", tc.header, err)
+		}
+		if tc.valid && sc.Traceparent()[3:52] != tc.header[3:52] {
+			t.Errorf("生成的 traceparent 异常: %s", sc.Traceparent())
+		}
+	}
+}
+
+// 收集导出请求的 Collector
+type collectorStub struct {
+	mu    sync.Mutex
+	spans map[string]otlpSpan
+	names map[string]string
+}
+
+func (s *collectorStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var traces otlpTraces
+	if r.URL.Path != "/v1/traces" || json.NewDecoder(r.Body).Decode(&traces) != nil {
+		w.WriteHeader(400)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rs := range traces.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			for _, span := range ss.Spans {
+				s.spans[span.Name] = span
+				s.names[span.SpanID] = span.Name
+			}
+		}
+		if v := rs.Resource.Attributes[0].Value.StringValue; v == nil || *v != "shop" {
+			w.WriteHeader(400)
+		}
+	}
+}
+
+func attribute(span otlpSpan, key string) otlpValue {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return otlpValue{}
+}
+
+// 测试请求 Span、子 Span、向下游传递以及 OTLP 导出
+func TestTracing(t *testing.T) {
+	collector := &collectorStub{spans: map[string]otlpSpan{}, names: map[string]string{}}
+	collectorServer := httptest.NewServer(collector)
+	defer collectorServer.Close()
+	var exportErr error
+	tracer := NewTracer(TracerConfig{
+		Exporter: NewOTLPExporter(OTLPConfig{Endpoint: collectorServer.URL + "/v1/traces", ServiceName: "shop"}),
+		OnError:  func(err error) { exportErr = err },
+	})
+
+	var downstreamParent string
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downstreamParent = r.Header.Get(HEADER_TRACEPARENT)
+		w.WriteHeader(503)
+	}))
+	defer downstream.Close()
+
+	c := CreateCapybaraInstance()
+	c.Use(Tracing(tracer))
+	c.GET("/orders/:id", func(ctx Context) {
+		span := ctx.StartSpan("load order")
+		span.SetAttribute("order.id", ctx.Param("id"))
+		span.End()
+		req, _ := http.NewRequestWithContext(ctx.Request().Context(), "GET", downstream.URL, nil)
+		if resp, err := NewHTTPClient().Do(req); err == nil {
+			resp.Body.Close()
+		}
+		ctx.String(500, "failed")
+	})
+	r := httptest.NewRequest("GET", "/orders/7", nil)
+	r.Header.Set(HEADER_TRACEPARENT, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.Header.Set(HEADER_TRACESTATE, "vendor=1")
+	c.ServeHTTP(httptest.NewRecorder(), r)
+
+	if err := tracer.Shutdown(gocontext.Background()); err != nil || exportErr != nil {
+		t.Fatalf("导出失败: %v %v", err, exportErr)
+	}
+	server, child, client := collector.spans["GET /orders/:id"], collector.spans["load order"], collector.spans["GET"]
+	if server.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || server.ParentSpanID != "00f067aa0ba902b7" || server.TraceState != "vendor=1" {
+		t.Errorf("没有延续上游的 trace: %+v", server)
+	}
+	if server.Kind != SPAN_KIND_SERVER || server.Status.Code != SPAN_STATUS_ERROR {
+		t.Errorf("请求 Span 异常: %+v", server)
+	}
+	if v := attribute(server, "http.response.status_code").IntValue; v == nil || *v != "500" {
+		t.Errorf("状态码属性异常: %+v", server.Attributes)
+	}
+	if v := attribute(server, "http.route").StringValue; v == nil || *v != "/orders/:id" {
+		t.Errorf("路由属性异常: %+v", server.Attributes)
+	}
+	if collector.names[child.ParentSpanID] != server.Name || collector.names[client.ParentSpanID] != server.Name {
+		t.Errorf("子 Span 的父子关系异常: %s %s", child.ParentSpanID, client.ParentSpanID)
+	}
+	if client.Kind != SPAN_KIND_CLIENT || client.Status.Code != SPAN_STATUS_ERROR {
+		t.Errorf("客户端 Span 异常: %+v", client)
+	}
+	if downstreamParent != "00-"+server.TraceID+"-"+client.SpanID+"-01" {
+		t.Errorf("下游收到的 traceparent 异常: %s", downstreamParent)
+	}
+}
+
+// 测试子 Span 成为当前 Span，结束后恢复父 Span
+func TestStartSpanNesting(t *testing.T) {
+	tracer := NewTracer(TracerConfig{})
+	defer tracer.Shutdown(gocontext.Background())
+	c := CreateCapybaraInstance()
+	c.Use(Tracing(tracer))
+	c.GET("/", func(ctx Context) {
+		root := SpanFromContext(ctx.Request().Context())
+		outer := ctx.StartSpan("outer")
+		if SpanFromContext(ctx.Request().Context()) != outer {
+			t.Error("子 Span 没有成为当前 Span")
+		}
+		inner := ctx.StartSpan("inner")
+		if inner.Parent != outer.Context.SpanID || outer.Parent != root.Context.SpanID {
+			t.Error("嵌套 Span 的父子关系异常")
+		}
+		req, _ := http.NewRequestWithContext(ctx.Request().Context(), "GET", "/", nil)
+		if SpanFromContext(req.Context()) != inner {
+			t.Error("发出的请求没有以子 Span 为父 Span")
+		}
+		inner.End()
+		if SpanFromContext(ctx.Request().Context()) != outer {
+			t.Error("结束后没有恢复父 Span")
+		}
+		outer.End()
+		if SpanFromContext(ctx.Request().Context()) != root {
+			t.Error("结束后没有恢复请求的 Span")
+		}
+	})
+	c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
+
+type spanRecorder struct {
+	spans []*Span
+}
+
+func (r *spanRecorder) ExportSpans(ctx gocontext.Context, spans []*Span) error {
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func (r *spanRecorder) Shutdown(ctx gocontext.Context) error {
+	return nil
+}
+
+// 测试注册在 Recovery 里层时 panic 的请求 Span 记为错误
+func TestTracingPanic(t *testing.T) {
+	recorder := &spanRecorder{}
+	tracer := NewTracer(TracerConfig{Exporter: recorder})
+	c := CreateCapybaraInstance()
+	c.Logger = NewLogger(LoggerConfig{Output: io.Discard})
+	c.Use(Recovery(), Tracing(tracer))
+	c.GET("/panic", func(ctx Context) {
+		panic("boom")
+	})
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("GET", "/panic", nil))
+	tracer.Shutdown(gocontext.Background())
+
+	if w.Code != 500 {
+		t.Errorf("panic 没有继续交给 Recovery 处理: %d", w.Code)
+	}
+	if len(recorder.spans) != 1 {
+		t.Fatalf("Span 数量异常: %d", len(recorder.spans))
+	}
+	span := recorder.spans[0]
+	if span.Status != SPAN_STATUS_ERROR || span.Attributes["http.response.status_code"] != 500 {
+		t.Errorf("panic 的请求 Span 异常: %d %v", span.Status, span.Attributes)
+	}
+}
+
+// 测试没有 Tracing 中间件时子 Span 可以安全使用
+func TestStartSpanWithoutTracer(t *testing.T) {
+	ctx, _ := newTestContext(CreateCapybaraInstance(), httptest.NewRequest("GET", "/", nil))
+	span := ctx.StartSpan("noop")
+	span.SetAttribute("key", "value")
+	span.RecordError(ErrInvalidTraceparent)
+	span.End()
+	if span != nil {
+		t.Error("没有 Tracer 时应返回 nil")
+	}
+}
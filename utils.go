@@ -1,7 +1,9 @@
 package capybara
 
 import (
+	"strconv"
 	"strings"
+	"unicode"
 )
 
 // 辅助函数：合并前缀和路径，处理斜杠问题
@@ -39,3 +41,104 @@ func splitPath(path string) []string {
 func checkPath(path string) bool {
 	return strings.HasPrefix(path, "/")
 }
+
+// 生成 Content-Disposition 的值
+//
+// 非 ASCII 的文件名按照 RFC 6266 / RFC 5987 编码为 filename*，
+// 同时保留一个 ASCII 的 filename 以兼容旧的浏览器
+//
+//	attachment; filename="__.txt"; filename*=UTF-8''%E4%BD%A0%E5%A5%BD.txt
+func formatContentDisposition(dispositionType string, name string) string {
+	if name == "" {
+		return dispositionType
+	}
+	ascii := true
+	var fallback strings.Builder
+	for _, r := range name {
+		switch {
+		case r > unicode.MaxASCII || r < 0x20 || r == 0x7f:
+			ascii = false
+			fallback.WriteByte('_')
+		case r == '"' || r == '\\':
+			fallback.WriteByte('\\')
+			fallback.WriteRune(r)
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+	value := dispositionType + `; filename="` + fallback.String() + `"`
+	if !ascii {
+		value += "; filename*=UTF-8''" + encodeRFC5987(name)
+	}
+	return value
+}
+
+// 按照 RFC 5987 的 attr-char 规则进行百分号编码
+func encodeRFC5987(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if isAttrChar(ch) {
+			b.WriteByte(ch)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[ch>>4])
+		b.WriteByte(hex[ch&0x0f])
+	}
+	return b.String()
+}
+
+func isAttrChar(ch byte) bool {
+	if 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || '0' <= ch && ch <= '9' {
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", ch) != -1
+}
+
+// 解析 Accept / Accept-Encoding 这类带 q 值的请求头中的一项
+type acceptSpec struct {
+	value string
+	q     float64
+}
+
+// 按照出现顺序返回请求头中的每一项及其 q 值
+//
+//	gzip;q=0.8, br  =>  [{gzip 0.8} {br 1}]
+func parseAccept(header string) []acceptSpec {
+	specs := make([]acceptSpec, 0)
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		value := strings.ToLower(strings.TrimSpace(fields[0]))
+		if value == "" {
+			continue
+		}
+		spec := acceptSpec{value: value, q: 1}
+		for _, param := range fields[1:] {
+			key, v, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.TrimSpace(key) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					spec.q = q
+				}
+			}
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// 判断 Accept-Encoding 是否接受某种编码
+func acceptsEncoding(header string, encoding string) bool {
+	accepted := false
+	for _, spec := range parseAccept(header) {
+		switch spec.value {
+		case encoding:
+			// 明确列出的编码优先于 *
+			return spec.q > 0
+		case "*":
+			accepted = spec.q > 0
+		}
+	}
+	return accepted
+}
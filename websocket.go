@@ -0,0 +1,673 @@
+package capybara
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// WebSocket 消息类型，与 RFC 6455 的 opcode 一致
+const (
+	CONTINUATION_FRAME = 0
+	TEXT_MESSAGE       = 1
+	BINARY_MESSAGE     = 2
+	CLOSE_MESSAGE      = 8
+	PING_MESSAGE       = 9
+	PONG_MESSAGE       = 10
+)
+
+// WebSocket 关闭码
+const (
+	CLOSE_NORMAL_CLOSURE        = 1000
+	CLOSE_GOING_AWAY            = 1001
+	CLOSE_PROTOCOL_ERROR        = 1002
+	CLOSE_UNSUPPORTED_DATA      = 1003
+	CLOSE_NO_STATUS_RECEIVED    = 1005
+	CLOSE_ABNORMAL_CLOSURE      = 1006
+	CLOSE_INVALID_FRAME_PAYLOAD = 1007
+	CLOSE_POLICY_VIOLATION      = 1008
+	CLOSE_MESSAGE_TOO_BIG       = 1009
+	CLOSE_MANDATORY_EXTENSION   = 1010
+	CLOSE_INTERNAL_SERVER_ERROR = 1011
+	CLOSE_TLS_HANDSHAKE         = 1015
+)
+
+const (
+	defaultWebSocketReadLimit    = 32 << 20
+	maxControlFramePayloadLength = 125
+	websocketAcceptGUID          = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	websocketVersion             = "13"
+	permessageDeflate            = "permessage-deflate"
+	permessageDeflateNoTakeover  = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+)
+
+var (
+	ErrNotWebSocket   = errors.New("websocket: not a websocket handshake")
+	ErrBadOrigin      = errors.New("websocket: request origin not allowed")
+	ErrReadLimit      = errors.New("websocket: read limit exceeded")
+	ErrCloseSent      = errors.New("websocket: close sent")
+	ErrProtocol       = errors.New("websocket: protocol error")
+	ErrInvalidUTF8    = errors.New("websocket: invalid utf8 in text message")
+	ErrInvalidControl = errors.New("websocket: invalid control frame")
+)
+
+// 对端发送关闭帧时 ReadMessage 返回的错误
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return "websocket: close " + strconv.Itoa(e.Code) + " " + e.Text
+}
+
+// WebSocket 握手与连接的配置
+type WebSocketConfig struct {
+	// 检查请求的 Origin，返回 false 时拒绝握手；为空时只允许同源请求或没有 Origin 的请求
+	CheckOrigin func(r *http.Request) bool
+	// 单条消息的最大长度，默认 32MB
+	ReadLimit int64
+	// 服务端支持的子协议，按优先级排列
+	Subprotocols []string
+	// 是否启用 permessage-deflate 压缩
+	EnableCompression bool
+}
+
+// 一个 WebSocket 连接
+//
+// 同一时间只能有一个 goroutine 读，写操作可以并发
+type WebSocketConn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	subprotocol string
+	compress    bool
+	readLimit   int64
+
+	writeMu   sync.Mutex
+	closeSent bool
+
+	pingHandler func(data string) error
+	pongHandler func(data string) error
+}
+
+// 将当前请求升级为 WebSocket 连接
+//
+//	c.GET("/ws", func(ctx capybara.Context) {
+//		conn, err := ctx.Upgrade()
+//		if err != nil {
+//			return
+//		}
+//		defer conn.Close(capybara.CLOSE_NORMAL_CLOSURE, "")
+//		for {
+//			mt, msg, err := conn.ReadMessage()
+//			if err != nil {
+//				return
+//			}
+//			conn.WriteMessage(mt, msg)
+//		}
+//	})
+func (c *context) Upgrade() (*WebSocketConn, error) {
+	var config WebSocketConfig
+	if c.capa != nil {
+		config = c.capa.WebSocket
+	}
+	r := c.r
+	if r.Method != http.MethodGet ||
+		!headerContainsToken(r.Header, "Connection", "upgrade") ||
+		!headerContainsToken(r.Header, "Upgrade", "websocket") {
+		c.capa.sendError(http.StatusBadRequest, c.w, c.r, "not a websocket handshake")
+		return nil, ErrNotWebSocket
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != websocketVersion {
+		c.w.Header().Set("Sec-WebSocket-Version", websocketVersion)
+		c.capa.sendError(http.StatusUpgradeRequired, c.w, c.r, "unsupported websocket version")
+		return nil, ErrNotWebSocket
+	}
+	key := strings.TrimSpace(r.Header.Get("Sec-WebSocket-Key"))
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		c.capa.sendError(http.StatusBadRequest, c.w, c.r, "invalid Sec-WebSocket-Key")
+		return nil, ErrNotWebSocket
+	}
+	checkOrigin := config.CheckOrigin
+	if checkOrigin == nil {
+		// 经过受信任的代理时使用代理转发的主机
+		host := c.Host()
+		checkOrigin = func(r *http.Request) bool {
+			return sameOrigin(r, host)
+		}
+	}
+	if !checkOrigin(r) {
+		c.capa.sendError(http.StatusForbidden, c.w, c.r, "origin not allowed")
+		return nil, ErrBadOrigin
+	}
+
+	subprotocol := selectSubprotocol(r, config.Subprotocols)
+	compress := config.EnableCompression && acceptsPermessageDeflate(r)
+
+	netConn, brw, err := http.NewResponseController(c.w).Hijack()
+	if err != nil {
+		c.capa.sendError(http.StatusInternalServerError, c.w, c.r, "")
+		return nil, err
+	}
+	// 连接已经被接管，之后的中间件和错误处理不能再写响应，访问日志与指标记录为 101
+	c.resp.Status = http.StatusSwitchingProtocols
+	c.resp.Committed = true
+
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	// 带上中间件已经设置的响应头，例如 X-Request-Id
+	c.resp.Header().WriteSubset(&b, handshakeHeaders)
+	b.WriteString("Sec-WebSocket-Accept: " + websocketAccept(key) + "\r\n")
+	if subprotocol != "" {
+		b.WriteString("Sec-WebSocket-Protocol: " + subprotocol + "\r\n")
+	}
+	if compress {
+		b.WriteString("Sec-WebSocket-Extensions: " + permessageDeflateNoTakeover + "\r\n")
+	}
+	b.WriteString("\r\n")
+	// 握手阶段不允许超时设置残留到后续的读写
+	netConn.SetDeadline(time.Time{})
+	if _, err := netConn.Write([]byte(b.String())); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	readLimit := config.ReadLimit
+	if readLimit <= 0 {
+		readLimit = defaultWebSocketReadLimit
+	}
+	ws := &WebSocketConn{
+		conn:        netConn,
+		br:          brw.Reader,
+		subprotocol: subprotocol,
+		compress:    compress,
+		readLimit:   readLimit,
+	}
+	return ws, nil
+}
+
+// 计算 Sec-WebSocket-Accept
+func websocketAccept(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketAcceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// 握手响应中由 Upgrade 自己生成、不从响应头复制的字段
+var handshakeHeaders = map[string]bool{
+	"Upgrade":                  true,
+	"Connection":               true,
+	"Sec-Websocket-Accept":     true,
+	"Sec-Websocket-Protocol":   true,
+	"Sec-Websocket-Extensions": true,
+	"Content-Length":           true,
+	"Content-Type":             true,
+	"Transfer-Encoding":        true,
+}
+
+// 默认的 Origin 检查：没有 Origin 或者 Origin 的主机与请求的主机相同
+func sameOrigin(r *http.Request, host string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, host)
+}
+
+// 判断逗号分隔的请求头中是否包含某个 token，忽略大小写
+func headerContainsToken(header http.Header, key string, token string) bool {
+	for _, value := range header.Values(key) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 按服务端的优先级选择客户端也支持的子协议
+func selectSubprotocol(r *http.Request, supported []string) string {
+	for _, s := range supported {
+		if headerContainsToken(r.Header, "Sec-WebSocket-Protocol", s) {
+			return s
+		}
+	}
+	return ""
+}
+
+// 客户端是否请求了 permessage-deflate
+func acceptsPermessageDeflate(r *http.Request) bool {
+	for _, value := range r.Header.Values("Sec-WebSocket-Extensions") {
+		for _, ext := range strings.Split(value, ",") {
+			name, _, _ := strings.Cut(ext, ";")
+			if strings.EqualFold(strings.TrimSpace(name), permessageDeflate) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 协商出的子协议
+func (ws *WebSocketConn) Subprotocol() string {
+	return ws.subprotocol
+}
+
+// 对端地址
+func (ws *WebSocketConn) RemoteAddr() net.Addr {
+	return ws.conn.RemoteAddr()
+}
+
+// 设置单条消息的最大长度
+func (ws *WebSocketConn) SetReadLimit(limit int64) {
+	ws.readLimit = limit
+}
+
+func (ws *WebSocketConn) SetReadDeadline(t time.Time) error {
+	return ws.conn.SetReadDeadline(t)
+}
+
+func (ws *WebSocketConn) SetWriteDeadline(t time.Time) error {
+	return ws.conn.SetWriteDeadline(t)
+}
+
+// 设置收到 ping 时的处理函数，默认回复一个相同内容的 pong
+func (ws *WebSocketConn) SetPingHandler(h func(data string) error) {
+	ws.pingHandler = h
+}
+
+// 设置收到 pong 时的处理函数
+func (ws *WebSocketConn) SetPongHandler(h func(data string) error) {
+	ws.pongHandler = h
+}
+
+// 帧头
+type frameHeader struct {
+	fin    bool
+	rsv1   bool
+	opcode int
+	length int64
+	mask   [4]byte
+}
+
+func (ws *WebSocketConn) readFrameHeader() (frameHeader, error) {
+	var h frameHeader
+	var b [8]byte
+	if _, err := io.ReadFull(ws.br, b[:2]); err != nil {
+		return h, err
+	}
+	h.fin = b[0]&0x80 != 0
+	h.rsv1 = b[0]&0x40 != 0
+	h.opcode = int(b[0] & 0x0f)
+	if b[0]&0x30 != 0 {
+		return h, ErrProtocol
+	}
+	masked := b[1]&0x80 != 0
+	h.length = int64(b[1] & 0x7f)
+	switch h.length {
+	case 126:
+		if _, err := io.ReadFull(ws.br, b[:2]); err != nil {
+			return h, err
+		}
+		h.length = int64(binary.BigEndian.Uint16(b[:2]))
+	case 127:
+		if _, err := io.ReadFull(ws.br, b[:8]); err != nil {
+			return h, err
+		}
+		length := binary.BigEndian.Uint64(b[:8])
+		if length > 1<<63-1 {
+			return h, ErrProtocol
+		}
+		h.length = int64(length)
+	}
+	// 客户端发来的帧必须带掩码
+	if !masked {
+		return h, ErrProtocol
+	}
+	if _, err := io.ReadFull(ws.br, h.mask[:]); err != nil {
+		return h, err
+	}
+	return h, nil
+}
+
+func (ws *WebSocketConn) readPayload(h frameHeader) ([]byte, error) {
+	payload := make([]byte, h.length)
+	if _, err := io.ReadFull(ws.br, payload); err != nil {
+		return nil, err
+	}
+	for i := range payload {
+		payload[i] ^= h.mask[i%4]
+	}
+	return payload, nil
+}
+
+// 读取一条完整的消息，分片会被合并，控制帧在内部处理
+//
+// 对端关闭连接时返回 *CloseError
+func (ws *WebSocketConn) ReadMessage() (messageType int, data []byte, err error) {
+	var (
+		message    []byte
+		inMessage  bool
+		compressed bool
+	)
+	for {
+		h, err := ws.readFrameHeader()
+		if err != nil {
+			return 0, nil, ws.fail(err)
+		}
+		if h.rsv1 && (!ws.compress || h.opcode == CONTINUATION_FRAME || h.opcode >= CLOSE_MESSAGE) {
+			return 0, nil, ws.fail(ErrProtocol)
+		}
+
+		if h.opcode >= CLOSE_MESSAGE {
+			if h.length > maxControlFramePayloadLength || !h.fin {
+				return 0, nil, ws.fail(ErrInvalidControl)
+			}
+			payload, err := ws.readPayload(h)
+			if err != nil {
+				return 0, nil, ws.fail(err)
+			}
+			if err := ws.handleControl(h.opcode, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+
+		switch h.opcode {
+		case TEXT_MESSAGE, BINARY_MESSAGE:
+			if inMessage {
+				return 0, nil, ws.fail(ErrProtocol)
+			}
+			inMessage = true
+			messageType = h.opcode
+			compressed = h.rsv1
+		case CONTINUATION_FRAME:
+			if !inMessage {
+				return 0, nil, ws.fail(ErrProtocol)
+			}
+		default:
+			return 0, nil, ws.fail(ErrProtocol)
+		}
+
+		if int64(len(message))+h.length > ws.readLimit {
+			return 0, nil, ws.fail(ErrReadLimit)
+		}
+		payload, err := ws.readPayload(h)
+		if err != nil {
+			return 0, nil, ws.fail(err)
+		}
+		message = append(message, payload...)
+		if !h.fin {
+			continue
+		}
+
+		if compressed {
+			if message, err = ws.decompress(message); err != nil {
+				return 0, nil, ws.fail(err)
+			}
+		}
+		if messageType == TEXT_MESSAGE && !utf8.Valid(message) {
+			return 0, nil, ws.fail(ErrInvalidUTF8)
+		}
+		return messageType, message, nil
+	}
+}
+
+// 处理控制帧，收到关闭帧时回复关闭帧并返回 *CloseError
+func (ws *WebSocketConn) handleControl(opcode int, payload []byte) error {
+	switch opcode {
+	case PING_MESSAGE:
+		if ws.pingHandler != nil {
+			return ws.pingHandler(string(payload))
+		}
+		err := ws.WriteControl(PONG_MESSAGE, payload)
+		if err != nil && err != ErrCloseSent {
+			return err
+		}
+	case PONG_MESSAGE:
+		if ws.pongHandler != nil {
+			return ws.pongHandler(string(payload))
+		}
+	case CLOSE_MESSAGE:
+		closeErr := &CloseError{Code: CLOSE_NO_STATUS_RECEIVED}
+		switch {
+		case len(payload) == 1:
+			return ws.fail(ErrProtocol)
+		case len(payload) >= 2:
+			closeErr.Code = int(binary.BigEndian.Uint16(payload))
+			closeErr.Text = string(payload[2:])
+			if !validCloseCode(closeErr.Code) {
+				return ws.fail(ErrProtocol)
+			}
+			if !utf8.ValidString(closeErr.Text) {
+				return ws.fail(ErrInvalidUTF8)
+			}
+		}
+		replyCode := closeErr.Code
+		if replyCode == CLOSE_NO_STATUS_RECEIVED {
+			replyCode = CLOSE_NORMAL_CLOSURE
+		}
+		ws.Close(replyCode, "")
+		return closeErr
+	default:
+		return ws.fail(ErrProtocol)
+	}
+	return nil
+}
+
+// 关闭帧中允许出现的关闭码
+func validCloseCode(code int) bool {
+	switch code {
+	case CLOSE_NO_STATUS_RECEIVED, CLOSE_ABNORMAL_CLOSURE, CLOSE_TLS_HANDSHAKE:
+		return false
+	}
+	return code >= 1000 && code <= 1014 && code != 1004 || code >= 3000 && code <= 4999
+}
+
+// 出错时按照错误类型发送关闭帧并关闭连接
+func (ws *WebSocketConn) fail(err error) error {
+	code := 0
+	switch err {
+	case ErrProtocol, ErrInvalidControl:
+		code = CLOSE_PROTOCOL_ERROR
+	case ErrReadLimit:
+		code = CLOSE_MESSAGE_TOO_BIG
+	case ErrInvalidUTF8:
+		code = CLOSE_INVALID_FRAME_PAYLOAD
+	}
+	if code != 0 {
+		ws.Close(code, "")
+	} else {
+		ws.conn.Close()
+	}
+	return err
+}
+
+// permessage-deflate 解压
+func (ws *WebSocketConn) decompress(data []byte) ([]byte, error) {
+	// 补上发送方去掉的 0x00 0x00 0xff 0xff，以及一个结束块
+	tail := []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+	fr := flate.NewReader(io.MultiReader(bytes.NewReader(data), bytes.NewReader(tail)))
+	defer fr.Close()
+	out, err := io.ReadAll(io.LimitReader(fr, ws.readLimit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > ws.readLimit {
+		return nil, ErrReadLimit
+	}
+	return out, nil
+}
+
+// permessage-deflate 压缩
+func compressMessage(data []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	fw, err := flate.NewWriter(buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := fw.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte{0x00, 0x00, 0xff, 0xff}), nil
+}
+
+// 发送一条完整的消息
+func (ws *WebSocketConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != TEXT_MESSAGE && messageType != BINARY_MESSAGE {
+		return ws.WriteControl(messageType, data)
+	}
+	rsv1 := false
+	if ws.compress {
+		compressed, err := compressMessage(data)
+		if err != nil {
+			return err
+		}
+		data, rsv1 = compressed, true
+	}
+	return ws.writeFrame(true, rsv1, messageType, data)
+}
+
+// 发送文本消息
+func (ws *WebSocketConn) WriteText(s string) error {
+	return ws.WriteMessage(TEXT_MESSAGE, []byte(s))
+}
+
+// 发送 ping、pong 或 close 控制帧
+func (ws *WebSocketConn) WriteControl(messageType int, data []byte) error {
+	if messageType < CLOSE_MESSAGE || messageType > PONG_MESSAGE || len(data) > maxControlFramePayloadLength {
+		return ErrInvalidControl
+	}
+	return ws.writeFrame(true, false, messageType, data)
+}
+
+// 发送 ping
+func (ws *WebSocketConn) Ping(data []byte) error {
+	return ws.WriteControl(PING_MESSAGE, data)
+}
+
+// 返回一个分片写入器，每次 Write 发送一个分片，Close 时发送最后一个分片
+//
+// 启用压缩时会在 Close 时压缩后一次性发送
+func (ws *WebSocketConn) NextWriter(messageType int) (io.WriteCloser, error) {
+	if messageType != TEXT_MESSAGE && messageType != BINARY_MESSAGE {
+		return nil, ErrProtocol
+	}
+	return &fragmentWriter{ws: ws, opcode: messageType}, nil
+}
+
+type fragmentWriter struct {
+	ws      *WebSocketConn
+	opcode  int
+	started bool
+	closed  bool
+	buf     []byte
+}
+
+func (fw *fragmentWriter) Write(p []byte) (int, error) {
+	if fw.closed {
+		return 0, ErrCloseSent
+	}
+	if fw.ws.compress {
+		fw.buf = append(fw.buf, p...)
+		return len(p), nil
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := fw.ws.writeFrame(false, false, fw.nextOpcode(), p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (fw *fragmentWriter) Close() error {
+	if fw.closed {
+		return nil
+	}
+	fw.closed = true
+	if fw.ws.compress {
+		return fw.ws.WriteMessage(fw.opcode, fw.buf)
+	}
+	return fw.ws.writeFrame(true, false, fw.nextOpcode(), nil)
+}
+
+func (fw *fragmentWriter) nextOpcode() int {
+	if fw.started {
+		return CONTINUATION_FRAME
+	}
+	fw.started = true
+	return fw.opcode
+}
+
+// 写一个帧，服务端发送的帧不带掩码
+func (ws *WebSocketConn) writeFrame(fin bool, rsv1 bool, opcode int, payload []byte) error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+	if ws.closeSent {
+		return ErrCloseSent
+	}
+	if opcode == CLOSE_MESSAGE {
+		ws.closeSent = true
+	}
+
+	header := make([]byte, 2, 10+len(payload))
+	header[0] = byte(opcode)
+	if fin {
+		header[0] |= 0x80
+	}
+	if rsv1 {
+		header[0] |= 0x40
+	}
+	switch length := len(payload); {
+	case length <= 125:
+		header[1] = byte(length)
+	case length <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+	_, err := ws.conn.Write(append(header, payload...))
+	return err
+}
+
+// 发送关闭帧并关闭底层连接
+func (ws *WebSocketConn) Close(code int, reason string) error {
+	var payload []byte
+	if code != CLOSE_NO_STATUS_RECEIVED {
+		payload = binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, reason...)
+		if len(payload) > maxControlFramePayloadLength {
+			payload = payload[:maxControlFramePayloadLength]
+		}
+	}
+	err := ws.writeFrame(true, false, CLOSE_MESSAGE, payload)
+	ws.conn.Close()
+	if err == ErrCloseSent {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,214 @@
+package capybara
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 测试用的最简 WebSocket 客户端
+type testWSClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func dialTestWS(t *testing.T, server *httptest.Server, header string) (*testWSClient, string) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := "GET /ws HTTP/1.1\r\nHost: " + strings.TrimPrefix(server.URL, "http://") + "\r\n" +
+		"Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n" + header + "\r\n"
+	conn.Write([]byte(req))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, resp.Status
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Error("Sec-WebSocket-Accept 计算错误")
+	}
+	return &testWSClient{conn: conn, br: br}, resp.Header.Get("Sec-WebSocket-Extensions")
+}
+
+func (c *testWSClient) writeFrame(fin bool, opcode int, payload []byte) {
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{b0, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, p := range payload {
+		frame = append(frame, p^mask[i%4])
+	}
+	c.conn.Write(frame)
+}
+
+func (c *testWSClient) readFrame() (opcode int, payload []byte) {
+	var h [2]byte
+	io.ReadFull(c.br, h[:])
+	length := int(h[1] & 0x7f)
+	if length == 126 {
+		var ext [2]byte
+		io.ReadFull(c.br, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload = make([]byte, length)
+	io.ReadFull(c.br, payload)
+	return int(h[0] & 0x0f), payload
+}
+
+func newEchoServer(config WebSocketConfig) *httptest.Server {
+	c := CreateCapybaraInstance()
+	c.WebSocket = config
+	c.GET("/ws", func(ctx Context) {
+		conn, err := ctx.Upgrade()
+		if err != nil {
+			return
+		}
+		for {
+			mt, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(mt, msg)
+		}
+	})
+	return httptest.NewServer(c)
+}
+
+// 测试握手、分片、ping 与关闭
+func TestWebSocketEcho(t *testing.T) {
+	server := newEchoServer(WebSocketConfig{})
+	defer server.Close()
+	client, _ := dialTestWS(t, server, "")
+	if client == nil {
+		t.Fatal("握手失败")
+	}
+	defer client.conn.Close()
+
+	client.writeFrame(false, TEXT_MESSAGE, []byte("hel"))
+	client.writeFrame(true, PING_MESSAGE, []byte("p"))
+	client.writeFrame(true, CONTINUATION_FRAME, []byte("lo"))
+	if op, payload := client.readFrame(); op != PONG_MESSAGE || string(payload) != "p" {
+		t.Errorf("ping 未回复 pong: %d %s", op, payload)
+	}
+	if op, payload := client.readFrame(); op != TEXT_MESSAGE || string(payload) != "hello" {
+		t.Errorf("分片消息合并失败: %d %s", op, payload)
+	}
+
+	client.writeFrame(true, CLOSE_MESSAGE, []byte{0x03, 0xe8})
+	if op, payload := client.readFrame(); op != CLOSE_MESSAGE || binary.BigEndian.Uint16(payload) != CLOSE_NORMAL_CLOSURE {
+		t.Errorf("关闭帧回复异常: %d %v", op, payload)
+	}
+}
+
+// 测试读取长度限制与非法 UTF-8
+func TestWebSocketLimits(t *testing.T) {
+	server := newEchoServer(WebSocketConfig{ReadLimit: 4})
+	defer server.Close()
+
+	client, _ := dialTestWS(t, server, "")
+	client.writeFrame(true, BINARY_MESSAGE, []byte("12345"))
+	if op, payload := client.readFrame(); op != CLOSE_MESSAGE || binary.BigEndian.Uint16(payload) != CLOSE_MESSAGE_TOO_BIG {
+		t.Errorf("超长消息未关闭连接: %d %v", op, payload)
+	}
+	client.conn.Close()
+
+	client, _ = dialTestWS(t, server, "")
+	client.writeFrame(true, TEXT_MESSAGE, []byte{0xff})
+	if op, payload := client.readFrame(); op != CLOSE_MESSAGE || binary.BigEndian.Uint16(payload) != CLOSE_INVALID_FRAME_PAYLOAD {
+		t.Errorf("非法 UTF-8 未关闭连接: %d %v", op, payload)
+	}
+	client.conn.Close()
+}
+
+// 测试 Origin 检查
+func TestWebSocketOrigin(t *testing.T) {
+	server := newEchoServer(WebSocketConfig{})
+	defer server.Close()
+	if client, status := dialTestWS(t, server, "Origin: http://evil.com\r\n"); client != nil || !strings.HasPrefix(status, "403") {
+		t.Errorf("跨域握手未被拒绝: %s", status)
+	}
+}
+
+// 测试 permessage-deflate
+func TestWebSocketCompression(t *testing.T) {
+	server := newEchoServer(WebSocketConfig{EnableCompression: true})
+	defer server.Close()
+	client, ext := dialTestWS(t, server, "Sec-WebSocket-Extensions: permessage-deflate\r\n")
+	if client == nil || !strings.HasPrefix(ext, "permessage-deflate") {
+		t.Fatalf("压缩扩展协商失败: %s", ext)
+	}
+	defer client.conn.Close()
+
+	compressed, _ := compressMessage([]byte("hello hello hello"))
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | 0x40 | TEXT_MESSAGE, 0x80 | byte(len(compressed))}
+	frame = append(frame, mask...)
+	for i, p := range compressed {
+		frame = append(frame, p^mask[i%4])
+	}
+	client.conn.Write(frame)
+
+	_, payload := client.readFrame()
+	ws := &WebSocketConn{readLimit: 1 << 10}
+	if msg, err := ws.decompress(payload); err != nil || string(msg) != "hello hello hello" {
+		t.Errorf("压缩消息往返失败: %v %s", err, msg)
+	}
+}
+
+// 测试握手带上中间件设置的响应头、记录 101，以及代理后的 Origin 检查
+func TestWebSocketHandshakeResponse(t *testing.T) {
+	recorded := make(chan *Response, 1)
+	c := CreateCapybaraInstance()
+	c.Use(RequestID(RequestIDConfig{Generator: func() string { return "req-ws" }}), func(next HandlerFunc) HandlerFunc {
+		return func(ctx Context) {
+			next(ctx)
+			resp := *ctx.Response()
+			recorded <- &resp
+		}
+	})
+	c.GET("/ws", func(ctx Context) {
+		conn, err := ctx.Upgrade()
+		if err != nil {
+			return
+		}
+		conn.Close(CLOSE_NORMAL_CLOSURE, "")
+	})
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	// 测试服务器的连接来自回环地址，属于受信任的代理
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: internal:8080\r\nX-Forwarded-Host: chat.example.com\r\n" +
+		"Origin: https://chat.example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("代理后的同源握手被拒绝: %s", resp.Status)
+	}
+	if resp.Header.Get(HEADER_X_REQUEST_ID) != "req-ws" {
+		t.Errorf("握手响应缺少中间件设置的响应头: %v", resp.Header)
+	}
+	if r := <-recorded; r.Status != http.StatusSwitchingProtocols || !r.Committed {
+		t.Errorf("响应状态没有记录为 101: %d %v", r.Status, r.Committed)
+	}
+}